@@ -0,0 +1,47 @@
+package background
+
+import (
+	"context"
+	"sync"
+)
+
+// Group tracks goroutines that outlive the request that started them, such
+// as mail delivery, so shutdown can wait for them to finish.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewGroup() *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in its own goroutine. The context given to fn is cancelled when
+// Stop is called; long-running workers should return once it is done, after
+// finishing the item they are working on.
+func (g *Group) Go(fn func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn(g.ctx)
+	}()
+}
+
+// Stop signals every goroutine to finish and waits for them, or until ctx
+// is done, in which case ctx's error is returned.
+func (g *Group) Stop(ctx context.Context) error {
+	g.cancel()
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
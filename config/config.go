@@ -0,0 +1,88 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Load reads settings from .env and, when CONFIG_FILE is set, from that file
+// too. Variables already present in the environment win. A missing .env is
+// not an error so the service can be configured from the environment alone.
+func Load() error {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		return godotenv.Load(file)
+	}
+	return nil
+}
+
+func String(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && strings.TrimSpace(v) != "" {
+		return strings.TrimSpace(v)
+	}
+	return def
+}
+
+func Int(key string, def int) int {
+	v := String(key, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("config: %s=%q is not an integer, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+func Bool(key string, def bool) bool {
+	v := String(key, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("config: %s=%q is not a boolean, using %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+// Duration accepts Go duration strings such as "10s" or "1h30m".
+func Duration(key string, def time.Duration) time.Duration {
+	v := String(key, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("config: %s=%q is not a duration, using %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// List splits a comma separated value, dropping empty entries.
+func List(key string, def []string) []string {
+	v := String(key, "")
+	if v == "" {
+		return def
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
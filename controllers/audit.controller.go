@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nanosoft/models"
+	"nanosoft/query"
+	"nanosoft/repository"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditController lets admins read the audit log that middleware.Audit
+// writes.
+type AuditController struct {
+	Audit repository.AuditRepository
+}
+
+func NewAuditController(store *repository.Store) *AuditController {
+	return &AuditController{Audit: store.Audit}
+}
+
+var auditListSpec = query.Spec{
+	Sortable: []string{"created_at", "status"},
+	Filterable: map[string]query.Kind{
+		"actor_id":    query.String,
+		"actor_email": query.String,
+		"action":      query.String,
+		"method":      query.String,
+		"resource":    query.String,
+		"resource_id": query.String,
+		"status":      query.Int,
+		"ip":          query.String,
+		"created_at":  query.Time,
+	},
+	DefaultSort: []query.SortField{{Field: "created_at", Desc: true}},
+}
+
+// exportBatch is how many entries an export reads at a time.
+const exportBatch = 500
+
+// GetAuditLog lists audit entries, newest first.
+func (ac *AuditController) GetAuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := query.Parse(c.Request.URL.Query(), auditListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		entries, err := ac.Audit.List(ctx, bson.M{}, list)
+		if err != nil {
+			log.Println("Error retrieving audit log:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving audit log"})
+			return
+		}
+
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// ExportAuditLog downloads every audit entry matching the same filters as
+// GetAuditLog, newest first, as CSV or, with format=json, a JSON array.
+func (ac *AuditController) ExportAuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", "csv")
+		if format != "csv" && format != "json" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+			return
+		}
+		values := c.Request.URL.Query()
+		values.Del("format")
+		list, err := query.Parse(values, auditListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Object IDs grow with time, so this is newest first and lets the
+		// export walk the log with a cursor.
+		list.Page, list.Limit = 1, exportBatch
+		list.Sort = []query.SortField{{Field: "_id", Desc: true}}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		page, err := ac.Audit.List(ctx, bson.M{}, list)
+		if err != nil {
+			log.Println("Error exporting audit log:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error exporting audit log"})
+			return
+		}
+
+		var write func(entry *models.AuditEntry) error
+		var finish func() error
+		if format == "json" {
+			c.Header("Content-Type", "application/json; charset=utf-8")
+			c.Header("Content-Disposition", `attachment; filename="audit-log.json"`)
+			c.Status(http.StatusOK)
+			c.Writer.WriteString("[")
+			enc := json.NewEncoder(c.Writer)
+			first := true
+			write = func(entry *models.AuditEntry) error {
+				if !first {
+					c.Writer.WriteString(",")
+				}
+				first = false
+				return enc.Encode(entry)
+			}
+			finish = func() error {
+				_, err := c.Writer.WriteString("]\n")
+				return err
+			}
+		} else {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Header("Content-Disposition", `attachment; filename="audit-log.csv"`)
+			c.Status(http.StatusOK)
+			w := csv.NewWriter(c.Writer)
+			w.Write(auditCSVHeader)
+			write = func(entry *models.AuditEntry) error {
+				return w.Write(auditCSVRecord(entry))
+			}
+			finish = func() error {
+				w.Flush()
+				return w.Error()
+			}
+		}
+
+		for {
+			for i := range page.Items {
+				if err := write(&page.Items[i]); err != nil {
+					log.Println("Error writing audit log export:", err)
+					return
+				}
+			}
+			if page.NextCursor == nil {
+				break
+			}
+			after, _ := primitive.ObjectIDFromHex(*page.NextCursor)
+			list.After = &after
+			if page, err = ac.Audit.List(ctx, bson.M{}, list); err != nil {
+				// The response has started, so the export just ends early.
+				log.Println("Error exporting audit log:", err)
+				return
+			}
+		}
+		if err := finish(); err != nil {
+			log.Println("Error writing audit log export:", err)
+		}
+	}
+}
+
+var auditCSVHeader = []string{
+	"id", "created_at", "actor_id", "actor_email", "action", "method", "path",
+	"resource", "resource_id", "status", "ip", "user_agent", "changes",
+}
+
+// auditCSVRecord flattens an entry into a CSV row. The changes are
+// written as JSON.
+func auditCSVRecord(entry *models.AuditEntry) []string {
+	changes := ""
+	if len(entry.Changes) > 0 {
+		if raw, err := json.Marshal(entry.Changes); err == nil {
+			changes = string(raw)
+		}
+	}
+	return []string{
+		entry.Audit_ID.Hex(),
+		entry.Created_At.UTC().Format(time.RFC3339),
+		entry.Actor_ID,
+		entry.Actor_Email,
+		entry.Action,
+		entry.Method,
+		entry.Path,
+		entry.Resource,
+		entry.Resource_ID,
+		strconv.Itoa(entry.Status),
+		entry.IP,
+		entry.User_Agent,
+		changes,
+	}
+}
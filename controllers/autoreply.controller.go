@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"nanosoft/config"
+	"nanosoft/mail"
+	"nanosoft/middleware"
+	"nanosoft/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AutoReplyPolicy controls the acknowledgement sent to whoever submits the
+// contact form. The address is whatever the visitor typed, so at most
+// PerAddress acknowledgements go to any one address however many clients
+// submit it.
+type AutoReplyPolicy struct {
+	Enabled       bool
+	PerAddress    middleware.Limit
+	DefaultLocale string
+}
+
+func LoadAutoReplyPolicy() AutoReplyPolicy {
+	policy := AutoReplyPolicy{
+		Enabled:    config.Bool("CONTACT_AUTO_REPLY", false),
+		PerAddress: middleware.LoadLimit("RATE_LIMIT_AUTO_REPLY", middleware.Limit{Name: "auto-reply", Requests: 3, Per: 24 * time.Hour}),
+	}
+	if locale, ok := mail.NormalizeLocale(config.String("CONTACT_DEFAULT_LOCALE", "")); ok {
+		policy.DefaultLocale = locale
+	}
+	return policy
+}
+
+// contactLocale picks the language of a contact message: the locale field
+// of the form if it has one, otherwise the browser's preferred language.
+// ok is false when the form names an invalid locale.
+func contactLocale(c *gin.Context, message *models.Message) (locale string, ok bool) {
+	if message.Locale != nil && *message.Locale != "" {
+		return mail.NormalizeLocale(*message.Locale)
+	}
+	first, _, _ := strings.Cut(c.GetHeader("Accept-Language"), ",")
+	first, _, _ = strings.Cut(first, ";")
+	if locale, ok := mail.NormalizeLocale(first); ok {
+		return locale, true
+	}
+	return "", true
+}
+
+// sendAcknowledgement queues the auto-reply to the sender of message, unless
+// auto-replies are off or the address has had its share of them.
+func (ec *EmailController) sendAcknowledgement(ctx context.Context, message *models.Message) error {
+	if !ec.AutoReply.Enabled || message.Email == nil {
+		return nil
+	}
+	address := strings.ToLower(strings.TrimSpace(*message.Email))
+	allowed, err := middleware.Allow(ctx, ec.Buckets, ec.AutoReply.PerAddress, address)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		log.Printf("Not acknowledging message %s: too many auto-replies to %s", message.Message_ID.Hex(), address)
+		return nil
+	}
+
+	locale := ec.AutoReply.DefaultLocale
+	if message.Locale != nil && *message.Locale != "" {
+		locale = *message.Locale
+	}
+	ack, err := ec.Templates.Compose(ctx, mail.TemplateContactAcknowledgement, locale, contactData(message))
+	if err != nil {
+		return err
+	}
+	ack.To = *message.Email
+	ack.Message_ID = message.Message_ID.Hex()
+	ack.Mail_ID = ec.threadRoot(message)
+	return ec.Outbox.Enqueue(ctx, ack)
+}
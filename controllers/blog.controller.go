@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"nanosoft/middleware"
+	"nanosoft/models"
+	"nanosoft/query"
+	"nanosoft/repository"
+	"nanosoft/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type BlogController struct {
+	Posts repository.PostRepository
+	// Files holds uploaded cover images, which are deleted with their post
+	// or when it no longer uses them.
+	Files storage.Storage
+}
+
+func NewBlogController(posts repository.PostRepository, files storage.Storage) *BlogController {
+	return &BlogController{Posts: posts, Files: files}
+}
+
+// Slugify turns a post title into a lower-case, dash separated URL segment.
+func Slugify(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(title)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+// uniqueSlug appends a numeric suffix to base until no other post uses it.
+func (bc *BlogController) uniqueSlug(ctx context.Context, base string, exclude primitive.ObjectID) (string, error) {
+	slug := base
+	for i := 2; ; i++ {
+		taken, err := bc.Posts.SlugTaken(ctx, slug, exclude)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func (bc *BlogController) CreatePost() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var post models.Post
+		if !bindJSON(c, &post) {
+			return
+		}
+		if post.Status == "" {
+			post.Status = models.PostDraft
+		}
+
+		post.Post_ID = primitive.NewObjectID()
+		if post.Slug == "" {
+			post.Slug = *post.Title
+		}
+		post.Slug = Slugify(post.Slug)
+		if post.Slug == "" {
+			post.Slug = post.Post_ID.Hex()
+		}
+		slug, err := bc.uniqueSlug(ctx, post.Slug, post.Post_ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating post"})
+			return
+		}
+		post.Slug = slug
+
+		uid, _ := c.Get("uid")
+		post.Author_ID, _ = uid.(string)
+		post.Created_At = time.Now()
+		post.Updated_At = time.Now()
+		if post.Status == models.PostPublished {
+			publishedAt := post.Created_At
+			post.Published_At = &publishedAt
+		}
+
+		err = bc.Posts.Create(ctx, &post)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating post"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Post created successfully", "slug": post.Slug})
+	}
+}
+
+// UpdatePost changes the title of a post and any of its slug, body, cover,
+// tags and status that the request sets. Fields left out, or sent as null,
+// keep their value.
+func (bc *BlogController) UpdatePost() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postID := c.Param("id")
+		objID, err := primitive.ObjectIDFromHex(postID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var post models.Post
+		if !bindJSON(c, &post) {
+			return
+		}
+
+		existing, err := bc.Posts.Get(ctx, objID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating post", "details": err.Error()})
+			return
+		}
+
+		slug := existing.Slug
+		if post.Slug != "" && Slugify(post.Slug) != existing.Slug {
+			slug, err = bc.uniqueSlug(ctx, Slugify(post.Slug), objID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating post", "details": err.Error()})
+				return
+			}
+		}
+
+		if post.Status == "" {
+			post.Status = existing.Status
+		}
+		post.Updated_At = time.Now()
+		publishedAt := existing.Published_At
+		if post.Status == models.PostPublished && publishedAt == nil {
+			publishedAt = &post.Updated_At
+		}
+
+		update := bson.M{
+			"title":        post.Title,
+			"slug":         slug,
+			"status":       post.Status,
+			"published_at": publishedAt,
+			"updated_at":   post.Updated_At,
+		}
+		if post.Body != nil {
+			update["body"] = post.Body
+		}
+		if post.Cover != nil {
+			update["cover"] = post.Cover
+		}
+		if post.Tags != nil {
+			update["tags"] = post.Tags
+		}
+
+		err = bc.Posts.Update(ctx, objID, update)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating post", "details": err.Error()})
+			return
+		}
+		if updated, err := bc.Posts.Get(ctx, objID); err == nil {
+			middleware.AuditChange(c, existing, updated)
+			storage.DeleteReplaced(ctx, bc.Files, existing.Files(), updated.Files())
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Post updated successfully", "slug": slug})
+	}
+}
+
+func (bc *BlogController) DeletePost() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postID := c.Param("id")
+		objID, err := primitive.ObjectIDFromHex(postID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		post, err := bc.Posts.Get(ctx, objID)
+		if err == nil {
+			err = bc.Posts.Delete(ctx, objID)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting post"})
+			return
+		}
+		storage.DeleteAll(ctx, bc.Files, post.Files())
+
+		c.JSON(http.StatusOK, gin.H{"message": "Post deleted successfully"})
+	}
+}
+
+var postListSpec = query.Spec{
+	Sortable: []string{"title", "published_at", "created_at", "updated_at"},
+	Filterable: map[string]query.Kind{
+		"title":        query.String,
+		"tags":         query.String,
+		"author_id":    query.String,
+		"published_at": query.Time,
+		"created_at":   query.Time,
+	},
+	DefaultSort: []query.SortField{{Field: "published_at", Desc: true}, {Field: "created_at", Desc: true}},
+}
+
+// GetAllPosts lists published posts, newest first.
+func (bc *BlogController) GetAllPosts() gin.HandlerFunc {
+	return bc.listPosts(bson.M{"status": models.PostPublished}, postListSpec)
+}
+
+// GetAllPostsAdmin lists every post, drafts included, for the admin dashboard.
+func (bc *BlogController) GetAllPostsAdmin() gin.HandlerFunc {
+	spec := postListSpec
+	spec.Filterable = map[string]query.Kind{"status": query.String}
+	for field, kind := range postListSpec.Filterable {
+		spec.Filterable[field] = kind
+	}
+	return bc.listPosts(bson.M{}, spec)
+}
+
+func (bc *BlogController) listPosts(filter bson.M, spec query.Spec) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := query.Parse(c.Request.URL.Query(), spec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		posts, err := bc.Posts.List(ctx, filter, list)
+		if err != nil {
+			log.Println("Error retrieving posts:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving posts"})
+			return
+		}
+
+		c.JSON(http.StatusOK, posts)
+	}
+}
+
+// GetOnePost returns a published post by its slug.
+func (bc *BlogController) GetOnePost() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		slug := c.Param("slug")
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		post, err := bc.Posts.FindBySlug(ctx, slug, bson.M{"status": models.PostPublished})
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error retrieving post: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving post", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, post)
+	}
+}
@@ -2,94 +2,203 @@ package controllers
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"strings"
 	"time"
 
-	"nanosoft/database"
+	"nanosoft/mail"
+	"nanosoft/middleware"
 	"nanosoft/models"
+	"nanosoft/query"
+	"nanosoft/repository"
+	"nanosoft/spam"
+	generate "nanosoft/tokens"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"gopkg.in/gomail.v2"
 )
 
-var EmailCollection *mongo.Collection = database.EmailData(database.Client, "Emails")
-
-func SendEmail(subject string, body string) error {
-	SMIP_HOST := os.Getenv("SMIP_HOST")
-	SMIP_PORT, portErr := strconv.Atoi(os.Getenv("SMIP_PORT"))
-	SMIP_MAIL := os.Getenv("SMIP_MAIL")
-	SMIP_PASSWORD := os.Getenv("SMIP_PASSWORD")
-	SMIP_RECEPT_MAIL := os.Getenv("SMIP_RECEPT_MAIL")
+type EmailController struct {
+	Messages  repository.MessageRepository
+	Users     repository.UserRepository
+	Outbox    *mail.Outbox
+	Templates *mail.Templates
+	Buckets   repository.BucketRepository
+	AutoReply AutoReplyPolicy
+	Spam      *spam.Filter
+}
 
-	if portErr != nil {
-		log.Printf("Error converting SMTP_PORT to integer: %v", portErr)
-		return portErr
+// NewEmailController takes the repositories it needs from store and its
+// auto-reply and spam policies from the environment.
+func NewEmailController(store *repository.Store, outbox *mail.Outbox, templates *mail.Templates) *EmailController {
+	return &EmailController{
+		Messages:  store.Messages,
+		Users:     store.Users,
+		Outbox:    outbox,
+		Templates: templates,
+		Buckets:   store.Buckets,
+		AutoReply: LoadAutoReplyPolicy(),
+		Spam:      spam.NewFilter(spam.LoadConfig(), store.Messages),
 	}
+}
 
-    m := gomail.NewMessage()
-    m.SetHeader("From", SMIP_MAIL)
-    m.SetHeader("To", SMIP_RECEPT_MAIL)
-    m.SetHeader("Subject", subject)
-    m.SetBody("text/html", body)
-
-    d := gomail.NewDialer(SMIP_HOST, SMIP_PORT, SMIP_MAIL, SMIP_PASSWORD)
-    d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
-
-    if err := d.DialAndSend(m); err != nil {
-        return err
-    }
-    return nil
+// contactForm is a contact form submission. It has only the fields a
+// visitor may set; the rest of the message is filled in by CreateEmail.
+// Website is a honeypot field that is hidden from people, Form_Token comes
+// from GetFormToken and Captcha_Token from the CAPTCHA widget, if one is
+// configured.
+type contactForm struct {
+	Name          *string `json:"name" validate:"omitempty,max=100"`
+	Email         *string `json:"email" validate:"required,email,max=254"`
+	Phone         *string `json:"phone" validate:"omitempty,max=40"`
+	CompanyName   *string `json:"company_name" validate:"omitempty,max=200"`
+	Message       *string `json:"message" validate:"required,notblank,max=10000"`
+	Locale        *string `json:"locale" validate:"omitempty,max=35"`
+	T1            *string `json:"t1" validate:"omitempty,max=500"`
+	T2            *string `json:"t2" validate:"omitempty,max=500"`
+	Website       string  `json:"website"`
+	Form_Token    string  `json:"form_token"`
+	Captcha_Token string  `json:"captcha_token"`
 }
 
-func CreateEmail() gin.HandlerFunc {
+// CreateEmail stores a contact form submission and queues the notification
+// to our inbox, so a slow or unavailable mail server neither delays the
+// visitor nor loses the message. The sender may also get an acknowledgement
+// quoting the message's reference. Submissions that look like spam are
+// stored with the spam status and not emailed; the reply does not tell.
+func (ec *EmailController) CreateEmail() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		
-		var message models.Message
-		if err := c.BindJSON(&message); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+		var form contactForm
+		if !bindJSON(c, &form) {
+			return
+		}
+		message := models.Message{
+			Name:        form.Name,
+			Email:       form.Email,
+			Phone:       form.Phone,
+			CompanyName: form.CompanyName,
+			Message:     form.Message,
+			Locale:      form.Locale,
+			T1:          form.T1,
+			T2:          form.T2,
+		}
+
+		locale, ok := contactLocale(c, &message)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid locale"})
 			return
 		}
+		message.Locale = nil
+		if locale != "" {
+			message.Locale = &locale
+		}
 
 		message.Message_ID = primitive.NewObjectID()
+		message.Status = models.MessageNew
+		message.Notes = []models.Note{}
+		message.Tags = []string{}
+		message.Thread = []models.Reply{}
 		message.Created_At = time.Now()
 		message.Updated_At = time.Now()
+		text := strings.Join([]string{deref(message.Name), deref(message.CompanyName), deref(message.Message)}, "\n")
+		message.IP = c.ClientIP()
+		message.Content_Hash = spam.ContentHash(text)
 
-		subject := "Email From Client"
-		emailBody := `
-			<h1>New Message from Client</h1>
-			<p><strong>Name:</strong> ` + *message.Name + `</p>
-			<p><strong>Email:</strong> ` + *message.Email + `</p>
-			<p><strong>Phone:</strong> ` + *message.Phone + `</p>
-			<p><strong>Company Name:</strong> ` + *message.CompanyName + `</p>
-			<p><strong>Message:</strong> ` + *message.Message + `</p>
-		`
-
-		emailErr := SendEmail(subject, emailBody)
-        if emailErr != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Error sending email", "details": emailErr.Error()})
-            return
-        }
+		reasons, err := ec.Spam.Check(ctx, spam.Submission{
+			IP:           message.IP,
+			Email:        deref(message.Email),
+			Text:         text,
+			Honeypot:     form.Website,
+			FormToken:    form.Form_Token,
+			CaptchaToken: form.Captcha_Token,
+			At:           message.Created_At,
+		})
+		if errors.Is(err, spam.ErrCaptchaFailed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "CAPTCHA verification failed"})
+			return
+		}
+		if len(reasons) > 0 {
+			message.Status = models.MessageSpam
+			message.Spam_Reasons = reasons
+		}
 
-		_, err := EmailCollection.InsertOne(ctx, message)
+		err = ec.Messages.Create(ctx, &message)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating message"})
 			return
 		}
 
-		c.JSON(http.StatusCreated, gin.H{"message": "Message sent successfully"})
+		if message.Status == models.MessageSpam {
+			log.Printf("Filed message %s from %s as spam: %s", message.Message_ID.Hex(), message.IP, strings.Join(reasons, ", "))
+		} else {
+			ec.notify(ctx, &message)
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Message sent successfully", "reference": message.Reference()})
+	}
+}
+
+// notify queues the notification to our inbox and the acknowledgement to
+// the sender of a new message. The message is stored and visible to admins
+// even if they cannot be queued.
+func (ec *EmailController) notify(ctx context.Context, message *models.Message) {
+	notification, err := ec.Templates.Compose(ctx, mail.TemplateContactNotification, "", contactData(message))
+	if err == nil {
+		notification.To = os.Getenv("SMIP_RECEPT_MAIL")
+		notification.Message_ID = message.Message_ID.Hex()
+		err = ec.Outbox.Enqueue(ctx, notification)
+	}
+	if err != nil {
+		log.Println("Error queueing contact notification:", err)
+	}
+	if err := ec.sendAcknowledgement(ctx, message); err != nil {
+		log.Println("Error queueing contact acknowledgement:", err)
+	}
+}
+
+// GetFormToken hands out the token the contact form sends back, which
+// shows how long the form was open.
+func (ec *EmailController) GetFormToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		formToken, err := generate.ContactFormTokenGenerator()
+		if err != nil {
+			log.Println("Error signing form token:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating form token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"form_token": formToken})
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// contactData copies message into template data. Optional fields left out
+// of the form are empty rather than nil.
+func contactData(message *models.Message) mail.ContactData {
+	return mail.ContactData{
+		Name:        deref(message.Name),
+		Email:       deref(message.Email),
+		Phone:       deref(message.Phone),
+		CompanyName: deref(message.CompanyName),
+		Message:     deref(message.Message),
+		Reference:   message.Reference(),
+		Received:    message.Created_At,
 	}
 }
 
-func DeleteEmail() gin.HandlerFunc {
+func (ec *EmailController) DeleteEmail() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		messageID := c.Param("id")
 		objID, err := primitive.ObjectIDFromHex(messageID)
@@ -101,35 +210,149 @@ func DeleteEmail() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		result, err := EmailCollection.DeleteOne(ctx, bson.M{"_id": objID})
+		before, err := ec.Messages.Get(ctx, objID)
+		if err == nil {
+			err = ec.Messages.Trash(ctx, objID, c.GetString("uid"))
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting message"})
 			return
 		}
+		if after, err := ec.Messages.GetTrashed(ctx, objID); err == nil {
+			middleware.AuditChange(c, before, after)
+		}
 
-		if result.DeletedCount == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		c.JSON(http.StatusOK, gin.H{"message": "Message moved to trash"})
+	}
+}
+
+// GetEmailTrash lists the messages in the trash.
+func (ec *EmailController) GetEmailTrash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := query.Parse(c.Request.URL.Query(), emailListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for i, cond := range list.Conditions {
+			list.Conditions[i] = inboxCondition(c, cond)
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		messages, err := ec.Messages.ListTrash(ctx, bson.M{}, list)
+		if err != nil {
+			log.Println("Error retrieving trashed messages:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving trash"})
+			return
+		}
+
+		c.JSON(http.StatusOK, messages)
+	}
+}
+
+// RestoreEmail takes a message out of the trash.
+func (ec *EmailController) RestoreEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		before, err := ec.Messages.GetTrashed(ctx, objID)
+		if err == nil {
+			err = ec.Messages.Restore(ctx, objID)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found in trash"})
+			return
+		}
+		if err != nil {
+			log.Println("Error restoring message:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error restoring message"})
 			return
 		}
+		ec.auditMessage(c, ctx, objID, before)
 
-		c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
+		c.JSON(http.StatusOK, gin.H{"message": "Message restored successfully"})
 	}
 }
 
-func GetAllEmails() gin.HandlerFunc {
+// PurgeEmail deletes a trashed message permanently.
+func (ec *EmailController) PurgeEmail() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var messages []models.Message
-		cursor, err := EmailCollection.Find(ctx, bson.M{})
+		trashed, err := ec.Messages.GetTrashed(ctx, objID)
+		if err == nil {
+			err = ec.Messages.Purge(ctx, objID)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found in trash"})
+			return
+		}
+		if err != nil {
+			log.Println("Error purging message:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting message"})
+			return
+		}
+		middleware.AuditChange(c, trashed, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Message deleted permanently"})
+	}
+}
+
+var emailListSpec = query.Spec{
+	Sortable: []string{"name", "email", "status", "created_at", "updated_at"},
+	Filterable: map[string]query.Kind{
+		"name":         query.String,
+		"email":        query.String,
+		"company_name": query.String,
+		"status":       query.String,
+		"assignee_id":  query.String,
+		"ip":           query.String,
+		"tags":         query.String,
+		"created_at":   query.Time,
+	},
+}
+
+// GetAllEmails lists messages. Besides the fields in emailListSpec they can
+// be filtered by assignee_id=me for the current admin's messages and
+// assignee_id=none for unassigned ones.
+func (ec *EmailController) GetAllEmails() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := query.Parse(c.Request.URL.Query(), emailListSpec)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving services"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		for i, cond := range list.Conditions {
+			list.Conditions[i] = inboxCondition(c, cond)
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
-		if err = cursor.All(ctx, &messages); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding messages"})
+		messages, err := ec.Messages.List(ctx, bson.M{}, list)
+		if err != nil {
+			log.Println("Error retrieving messages:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving messages"})
 			return
 		}
 
@@ -137,7 +360,10 @@ func GetAllEmails() gin.HandlerFunc {
 	}
 }
 
-func GetOneEmail() gin.HandlerFunc {
+// GetOneEmail returns a message with its notes, the thread of replies and
+// the delivery state of the emails queued about it, marking it read if it
+// was new.
+func (ec *EmailController) GetOneEmail() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		messageID := c.Param("id")
 		objID, err := primitive.ObjectIDFromHex(messageID)
@@ -145,19 +371,110 @@ func GetOneEmail() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
 			return
 		}
+		// Contact messages hold personal data, so reading one is audited.
+		middleware.AuditResource(c, "email", messageID)
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var message models.Message
-		err = EmailCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&message)
+		message, err := ec.Messages.Get(ctx, objID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
 		if err != nil {
 			// Log the error for debugging purposes
 			log.Printf("Error retrieving message: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving message", "details": err.Error()})
 			return
 		}
+		if message.Status == "" || message.Status == models.MessageNew {
+			now := time.Now()
+			if err := ec.Messages.MarkRead(ctx, objID, now); err == nil {
+				message.Status = models.MessageRead
+				message.Updated_At = now
+			} else if !errors.Is(err, repository.ErrNotFound) {
+				log.Println("Error marking message read:", err)
+			}
+		}
+		if err := ec.fillDeliveryStatus(ctx, message); err != nil {
+			log.Println("Error retrieving delivery status:", err)
+		}
 
 		c.JSON(http.StatusOK, message)
 	}
 }
+
+var outboxListSpec = query.Spec{
+	Sortable: []string{"created_at", "updated_at", "next_attempt_at", "attempts"},
+	Filterable: map[string]query.Kind{
+		"status":     query.String,
+		"kind":       query.String,
+		"to":         query.String,
+		"message_id": query.String,
+		"created_at": query.Time,
+	},
+	DefaultSort: []query.SortField{{Field: "created_at", Desc: true}},
+}
+
+// GetOutbox lists queued and delivered emails with their delivery status,
+// attempts and last error. Bodies of sensitive emails are left out.
+func (ec *EmailController) GetOutbox() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := query.Parse(c.Request.URL.Query(), outboxListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		emails, err := ec.Outbox.Repo.List(ctx, bson.M{}, list)
+		if err != nil {
+			log.Println("Error retrieving outbox:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving outbox"})
+			return
+		}
+		for i := range emails.Items {
+			if emails.Items[i].Sensitive {
+				emails.Items[i].Body = ""
+				emails.Items[i].Text = ""
+			}
+		}
+
+		c.JSON(http.StatusOK, emails)
+	}
+}
+
+// RetryOutboundEmail queues a failed email for delivery again.
+func (ec *EmailController) RetryOutboundEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email ID"})
+			return
+		}
+		middleware.AuditResource(c, "outbox", objID.Hex())
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err = ec.Outbox.Retry(ctx, objID)
+		if errors.Is(err, repository.ErrNotFound) {
+			if _, getErr := ec.Outbox.Repo.Get(ctx, objID); getErr == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "Only failed emails can be retried"})
+				return
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+			return
+		}
+		if err != nil {
+			log.Println("Error retrying email:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrying email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email queued for delivery"})
+	}
+}
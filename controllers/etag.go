@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// versionETag is the entity tag of one version of a document.
+func versionETag(id primitive.ObjectID, version int64) string {
+	return `"` + id.Hex() + "-" + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatch checks the If-Match header against the entity tag of the
+// document about to be changed. When the header is present and names
+// neither etag nor "*" it answers 412 and reports false.
+func ifMatch(c *gin.Context, etag string) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" || header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		// Weak tags never match here.
+		if strings.TrimSpace(tag) == etag {
+			return true
+		}
+	}
+	c.Header("ETag", etag)
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "The document has been modified; reload it and try again"})
+	return false
+}
+
+// notModified sets the ETag header and, when the If-None-Match header
+// names etag, answers 304 and reports true.
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// respondCached answers with value as JSON, tagged with a hash of the
+// body so an unchanged response can be revalidated with If-None-Match.
+func respondCached(c *gin.Context, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error encoding response"})
+		return
+	}
+	sum := sha256.Sum256(body)
+	if notModified(c, `"`+hex.EncodeToString(sum[:16])+`"`) {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"nanosoft/middleware"
+	"nanosoft/models"
+	"nanosoft/query"
+	"nanosoft/repository"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// inboxCondition resolves the special values of GetAllEmails filters.
+// Messages without a status are new, so status=new matches those as well.
+func inboxCondition(c *gin.Context, cond query.Condition) query.Condition {
+	switch {
+	case cond.Field == "assignee_id" && cond.Value == "me":
+		cond.Value = c.GetString("uid")
+	case cond.Field == "assignee_id" && cond.Value == "none":
+		cond.Value = nil
+	case cond.Field == "status" && cond.Op == "$eq" && cond.Value == models.MessageNew:
+		cond.Op = "$in"
+		cond.Value = bson.A{models.MessageNew, "", nil}
+	}
+	return cond
+}
+
+// UpdateEmailStatus moves a message through the inbox workflow.
+func (ec *EmailController) UpdateEmailStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+		var request struct {
+			Status string `json:"status" validate:"required,oneof=new read replied archived spam"`
+		}
+		if !bindJSON(c, &request) {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		ec.updateMessage(c, ctx, objID, bson.M{"status": request.Status, "updated_at": time.Now()})
+	}
+}
+
+// AssignEmail gives a message to an admin, or takes it back when the
+// assignee_id is empty.
+func (ec *EmailController) AssignEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+		var request struct {
+			Assignee_ID string `json:"assignee_id"`
+		}
+		if !bindJSON(c, &request) {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var assignee *string
+		if request.Assignee_ID != "" {
+			userID, err := primitive.ObjectIDFromHex(request.Assignee_ID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid assignee ID"})
+				return
+			}
+			user, err := ec.Users.Get(ctx, userID)
+			if errors.Is(err, repository.ErrNotFound) || (err == nil && !user.IsAdmin()) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Messages can only be assigned to admins"})
+				return
+			}
+			if err != nil {
+				log.Println("Error loading assignee:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error assigning message"})
+				return
+			}
+			assignee = &user.User_ID
+		}
+
+		ec.updateMessage(c, ctx, objID, bson.M{"assignee_id": assignee, "updated_at": time.Now()})
+	}
+}
+
+// UpdateEmailTags replaces the tags of a message. Tags are lower-cased and
+// duplicates dropped.
+func (ec *EmailController) UpdateEmailTags() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+		var request struct {
+			Tags []string `json:"tags" validate:"max=20,dive,max=40"`
+		}
+		if !bindJSON(c, &request) {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		ec.updateMessage(c, ctx, objID, bson.M{"tags": normalizeTags(request.Tags), "updated_at": time.Now()})
+	}
+}
+
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// updateMessage applies set to the message and answers with the result.
+func (ec *EmailController) updateMessage(c *gin.Context, ctx context.Context, id primitive.ObjectID, set bson.M) {
+	before, err := ec.Messages.Get(ctx, id)
+	if err == nil {
+		err = ec.Messages.Update(ctx, id, set)
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if err != nil {
+		log.Println("Error updating message:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating message"})
+		return
+	}
+	message, err := ec.Messages.Get(ctx, id)
+	if err != nil {
+		log.Println("Error retrieving message:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving message"})
+		return
+	}
+	middleware.AuditChange(c, before, message)
+	c.JSON(http.StatusOK, message)
+}
+
+// auditMessage records how the message with id changed from before in the
+// audit log.
+func (ec *EmailController) auditMessage(c *gin.Context, ctx context.Context, id primitive.ObjectID, before *models.Message) {
+	after, err := ec.Messages.Get(ctx, id)
+	if err != nil {
+		log.Println("Error retrieving message:", err)
+		return
+	}
+	middleware.AuditChange(c, before, after)
+}
+
+// AddEmailNote adds an internal note by the current admin to a message.
+func (ec *EmailController) AddEmailNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+		var request struct {
+			Body string `json:"body" validate:"required,max=5000"`
+		}
+		if !bindJSON(c, &request) {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		note := models.Note{
+			Note_ID:    primitive.NewObjectID(),
+			Author_ID:  c.GetString("uid"),
+			Body:       request.Body,
+			Created_At: time.Now(),
+		}
+		if authorID, err := primitive.ObjectIDFromHex(note.Author_ID); err == nil {
+			if author, err := ec.Users.Get(ctx, authorID); err == nil && author.Name != nil {
+				note.Author_Name = *author.Name
+			}
+		}
+
+		before, err := ec.Messages.Get(ctx, objID)
+		if err == nil {
+			err = ec.Messages.AddNote(ctx, objID, note)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		if err != nil {
+			log.Println("Error adding note:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error adding note"})
+			return
+		}
+		ec.auditMessage(c, ctx, objID, before)
+
+		c.JSON(http.StatusCreated, note)
+	}
+}
+
+// DeleteEmailNote removes a note. Only its author may remove it.
+func (ec *EmailController) DeleteEmailNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+		noteID, err := primitive.ObjectIDFromHex(c.Param("note_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		message, err := ec.Messages.Get(ctx, objID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		if err != nil {
+			log.Println("Error retrieving message:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting note"})
+			return
+		}
+		var note *models.Note
+		for i := range message.Notes {
+			if message.Notes[i].Note_ID == noteID {
+				note = &message.Notes[i]
+			}
+		}
+		if note == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+			return
+		}
+		if note.Author_ID != c.GetString("uid") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the author can delete a note"})
+			return
+		}
+
+		if err := ec.Messages.RemoveNote(ctx, objID, noteID); err != nil {
+			log.Println("Error deleting note:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting note"})
+			return
+		}
+		ec.auditMessage(c, ctx, objID, message)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
+	}
+}
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nanosoft/config"
+	"nanosoft/middleware"
+	"nanosoft/models"
+	"nanosoft/query"
+	"nanosoft/repository"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginPolicy limits failed logins. From DelayAfter failures within Window,
+// each further failure blocks the key for BaseDelay, doubling every time.
+// At the Max*Failures limit the key is locked out for LockoutDuration and
+// a models.Lockout is recorded.
+type LoginPolicy struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	DelayAfter         int
+	BaseDelay          time.Duration
+	Window             time.Duration
+	LockoutDuration    time.Duration
+}
+
+func LoadLoginPolicy() LoginPolicy {
+	return LoginPolicy{
+		MaxAccountFailures: config.Int("LOGIN_MAX_ACCOUNT_FAILURES", 5),
+		MaxIPFailures:      config.Int("LOGIN_MAX_IP_FAILURES", 20),
+		DelayAfter:         config.Int("LOGIN_DELAY_AFTER", 3),
+		BaseDelay:          config.Duration("LOGIN_BASE_DELAY", time.Second),
+		Window:             config.Duration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		LockoutDuration:    config.Duration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginBlockedFor returns how long logins are still refused for any of keys.
+func (uc *UserController) loginBlockedFor(ctx context.Context, keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now()
+	for _, key := range keys {
+		counter, err := uc.Attempts.Get(ctx, key)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if counter.Locked_Until != nil && counter.Locked_Until.Sub(now) > wait {
+			wait = counter.Locked_Until.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed login against the account and the
+// client address and applies the delay or lockout each has earned. user is
+// nil when no account has the email.
+func (uc *UserController) recordLoginFailure(ctx context.Context, c *gin.Context, email string, user *models.User) {
+	limits := map[string]int{
+		accountKey(email):   uc.Policy.MaxAccountFailures,
+		ipKey(c.ClientIP()): uc.Policy.MaxIPFailures,
+	}
+	for key, limit := range limits {
+		now := time.Now()
+		counter, err := uc.Attempts.RecordFailure(ctx, key, now, uc.Policy.Window)
+		if err != nil {
+			log.Println("Error recording login failure:", err)
+			continue
+		}
+
+		var until time.Time
+		switch {
+		case limit > 0 && counter.Failures >= limit:
+			until = now.Add(uc.Policy.LockoutDuration)
+		case uc.Policy.DelayAfter > 0 && counter.Failures >= uc.Policy.DelayAfter:
+			shift := counter.Failures - uc.Policy.DelayAfter
+			if shift > 16 {
+				shift = 16
+			}
+			until = now.Add(uc.Policy.BaseDelay << shift)
+		default:
+			continue
+		}
+		if err := uc.Attempts.Lock(ctx, key, until); err != nil {
+			log.Println("Error locking login key:", err)
+			continue
+		}
+		if limit <= 0 || counter.Failures < limit {
+			continue
+		}
+
+		lockout := models.Lockout{
+			Lockout_ID:   primitive.NewObjectID(),
+			Key:          key,
+			IP:           c.ClientIP(),
+			User_Agent:   c.Request.UserAgent(),
+			Failures:     counter.Failures,
+			Locked_Until: until,
+			Created_At:   now,
+		}
+		if key == accountKey(email) {
+			lockout.Email = email
+			if user != nil {
+				lockout.User_ID = user.User_ID
+			}
+		}
+		log.Printf("Locked out %s after %d failed logins until %s", key, counter.Failures, until.Format(time.RFC3339))
+		if err := uc.Lockouts.Create(ctx, &lockout); err != nil {
+			log.Println("Error recording lockout:", err)
+		}
+	}
+}
+
+func tooManyLoginAttempts(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later", "retry_after": seconds})
+}
+
+// UnlockUser lets an admin lift the lockout of the user in the path. The
+// counters of the addresses that failed to log in are left alone: the only
+// address on record is the one that tripped the lockout, which is as likely
+// the attacker's as the user's, and clearing it would let that address
+// carry on guessing. An admin who knows the user's address, say a shared
+// office one that is locked out too, can pass it as ip to lift its lockout
+// as well.
+func (uc *UserController) UnlockUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		var ip net.IP
+		if c.Query("ip") != "" {
+			if ip = net.ParseIP(c.Query("ip")); ip == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+				return
+			}
+		}
+
+		middleware.AuditResource(c, "user", userID.Hex())
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := uc.Users.Get(ctx, userID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Println("Error loading user to unlock:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+			return
+		}
+
+		keys := []string{accountKey(*user.Email)}
+		if ip != nil {
+			keys = append(keys, ipKey(ip.String()))
+		}
+		for _, key := range keys {
+			if err := uc.Attempts.Clear(ctx, key); err != nil {
+				log.Println("Error clearing login failures:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+				return
+			}
+			if err := uc.Lockouts.Unlock(ctx, key, c.GetString("uid"), time.Now()); err != nil {
+				log.Println("Error recording unlock:", err)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+	}
+}
+
+var lockoutListSpec = query.Spec{
+	Sortable: []string{"created_at", "locked_until"},
+	Filterable: map[string]query.Kind{
+		"key":        query.String,
+		"email":      query.String,
+		"user_id":    query.String,
+		"ip":         query.String,
+		"created_at": query.Time,
+	},
+	DefaultSort: []query.SortField{{Field: "created_at", Desc: true}},
+}
+
+// GetLockouts lists the lockout audit records, newest first.
+func (uc *UserController) GetLockouts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := query.Parse(c.Request.URL.Query(), lockoutListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		lockouts, err := uc.Lockouts.List(ctx, bson.M{}, list)
+		if err != nil {
+			log.Println("Error retrieving lockouts:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving lockouts"})
+			return
+		}
+
+		c.JSON(http.StatusOK, lockouts)
+	}
+}
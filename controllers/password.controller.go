@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"nanosoft/config"
+	"nanosoft/mail"
+	"nanosoft/models"
+	"nanosoft/repository"
+	generate "nanosoft/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const forgotPasswordReply = "If that email address is registered, a password reset link has been sent to it"
+
+// ForgotPassword emails a single-use reset link to a registered address.
+// The lookup and delivery happen in the background and the reply is the
+// same either way, so neither its content nor its timing reveals whether
+// the address is registered.
+func (uc *UserController) ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if !bindJSON(c, &request) {
+			return
+		}
+
+		ip := c.ClientIP()
+		uc.Workers.Go(func(context.Context) {
+			var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+			defer cancel()
+			if err := uc.sendPasswordReset(ctx, request.Email, ip); err != nil {
+				log.Println("Error sending password reset:", err)
+			}
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": forgotPasswordReply})
+	}
+}
+
+// sendPasswordReset replaces any outstanding reset links of the user with
+// email by a new one and queues it. Unknown addresses are silently ignored.
+func (uc *UserController) sendPasswordReset(ctx context.Context, email, ip string) error {
+	user, err := uc.Users.FindByEmail(ctx, email)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := uc.Resets.InvalidateUser(ctx, user.User_ID, now); err != nil {
+		return err
+	}
+	resetToken := generate.RandomToken()
+	ttl := generate.PasswordResetTTL()
+	reset := models.PasswordReset{
+		Reset_ID:   primitive.NewObjectID(),
+		User_ID:    user.User_ID,
+		Token_Hash: generate.HashToken(resetToken),
+		IP:         ip,
+		Expires_At: now.Add(ttl),
+		Created_At: now,
+	}
+	if err := uc.Resets.Create(ctx, &reset); err != nil {
+		return err
+	}
+
+	link, err := passwordResetLink(resetToken)
+	if err != nil {
+		return err
+	}
+	name := ""
+	if user.Name != nil {
+		name = *user.Name
+	}
+	resetEmail, err := uc.Templates.Compose(ctx, mail.TemplatePasswordReset, "", mail.LinkData{Name: name, Link: link, ExpiresIn: ttl.String()})
+	if err != nil {
+		return err
+	}
+	resetEmail.To = *user.Email
+	resetEmail.Sensitive = true
+	return uc.Outbox.Enqueue(ctx, resetEmail)
+}
+
+// passwordResetLink points at the frontend page that collects the new
+// password, configured by PASSWORD_RESET_URL.
+func passwordResetLink(resetToken string) (string, error) {
+	link, err := url.Parse(config.String("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"))
+	if err != nil {
+		return "", fmt.Errorf("invalid PASSWORD_RESET_URL: %w", err)
+	}
+	q := link.Query()
+	q.Set("token", resetToken)
+	link.RawQuery = q.Encode()
+	return link.String(), nil
+}
+
+// ResetPassword sets a new password using the token from a reset link. Any
+// other outstanding links and every session of the user are revoked.
+func (uc *UserController) ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Token       string `json:"token" validate:"required"`
+			NewPassword string `json:"new_password" validate:"required,min=6,maxbytes=72"`
+		}
+		if !bindJSON(c, &request) {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		now := time.Now()
+		reset, err := uc.Resets.Consume(ctx, generate.HashToken(request.Token), now)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is invalid or has expired"})
+			return
+		}
+		if err != nil {
+			log.Println("Error consuming password reset:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+
+		password, err := HashPassword(request.NewPassword)
+		if err != nil {
+			log.Println("Error hashing password:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+
+		// Following the emailed link proves the user owns the address.
+		userID, _ := primitive.ObjectIDFromHex(reset.User_ID)
+		err = uc.Users.Update(ctx, userID, bson.M{
+			"password":       password,
+			"email_verified": true,
+			"updated_at":     now,
+		})
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is invalid or has expired"})
+			return
+		}
+		if err != nil {
+			log.Println("Error updating password:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+
+		if err := uc.Resets.InvalidateUser(ctx, reset.User_ID, now); err != nil {
+			log.Println("Error invalidating password resets:", err)
+		}
+		if err := uc.revokeUserSessions(ctx, reset.User_ID, "password reset"); err != nil {
+			log.Println("Failed to revoke sessions after password reset:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Password reset but sessions could not be revoked"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+	}
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+
+	"nanosoft/patch"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPatchSize bounds the body of a PATCH request.
+const maxPatchSize = 1 << 20
+
+// applyPatch applies the PATCH request body to doc and decodes the result
+// into dst, which must not alias doc. The body is a JSON Patch when sent as
+// application/json-patch+json and a JSON Merge Patch otherwise. It returns
+// the top-level members the patch changes, which must all be writable. On
+// failure it answers the request and reports false: 400 for a malformed
+// patch or one that changes a member that is not writable, 409 when a test
+// operation fails and 422 when the patch does not fit the document.
+func applyPatch(c *gin.Context, doc, dst interface{}, writable func(field string) bool) ([]string, bool) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPatchSize+1))
+	if err != nil || len(body) > maxPatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return nil, false
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error applying patch"})
+		return nil, false
+	}
+	var current interface{}
+	if err := json.Unmarshal(raw, &current); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error applying patch"})
+		return nil, false
+	}
+
+	var patched interface{}
+	touched := map[string]bool{}
+	switch c.ContentType() {
+	case patch.JSONPatchType:
+		var ops []patch.Operation
+		if err := json.Unmarshal(body, &ops); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A JSON Patch must be an array of operations"})
+			return nil, false
+		}
+		for _, op := range ops {
+			for _, path := range op.Paths() {
+				tokens, err := patch.ParsePointer(path)
+				if err != nil || len(tokens) == 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path: " + path})
+					return nil, false
+				}
+				touched[tokens[0]] = true
+			}
+		}
+		if !onlyWritable(c, touched, writable) {
+			return nil, false
+		}
+		patched, err = patch.Apply(current, ops)
+		if errors.Is(err, patch.ErrTestFailed) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		if errors.Is(err, patch.ErrInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return nil, false
+		}
+	case patch.MergePatchType, "application/json", "":
+		var merge map[string]interface{}
+		if err := json.Unmarshal(body, &merge); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A merge patch must be a JSON object"})
+			return nil, false
+		}
+		for name := range merge {
+			touched[name] = true
+		}
+		if !onlyWritable(c, touched, writable) {
+			return nil, false
+		}
+		patched = patch.Merge(current, merge)
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Use " + patch.MergePatchType + " or " + patch.JSONPatchType})
+		return nil, false
+	}
+
+	raw, err = json.Marshal(patched)
+	if err == nil {
+		err = json.Unmarshal(raw, dst)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		respondInvalid(c, []FieldError{typeError(typeErr)})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "The patched document is not valid"})
+		return nil, false
+	}
+
+	return sortedFields(touched), true
+}
+
+// onlyWritable answers 400 and reports false when a touched member is not
+// writable. It is checked before the patch is applied, so a read-only
+// member is reported as such whatever value the patch gives it.
+func onlyWritable(c *gin.Context, touched map[string]bool, writable func(field string) bool) bool {
+	var readonly []FieldError
+	for _, field := range sortedFields(touched) {
+		if !writable(field) {
+			readonly = append(readonly, FieldError{Field: field, Code: "readonly", Message: field + " cannot be changed"})
+		}
+	}
+	if len(readonly) > 0 {
+		respondInvalid(c, readonly)
+		return false
+	}
+	return true
+}
+
+func sortedFields(set map[string]bool) []string {
+	fields := make([]string, 0, len(set))
+	for name := range set {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"nanosoft/mail"
+	"nanosoft/models"
+	"nanosoft/query"
+	"nanosoft/repository"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxReferences bounds the References header of long threads. The root is
+// always kept, followed by the most recent replies.
+const maxReferences = 10
+
+// threadRoot is the Message-ID every email about message refers back to.
+// The acknowledgement is sent with it, so replies thread under it in the
+// sender's mail client.
+func (ec *EmailController) threadRoot(message *models.Message) string {
+	for _, reply := range message.Thread {
+		if len(reply.References) > 0 {
+			return reply.References[0]
+		}
+	}
+	return mail.MessageIDFor("contact."+message.Message_ID.Hex(), ec.Outbox.Config.From)
+}
+
+// ReplyToEmail emails an admin's reply to the sender of a message and adds
+// it to the message's thread.
+func (ec *EmailController) ReplyToEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+		var request struct {
+			Subject string `json:"subject" validate:"max=200"`
+			Body    string `json:"body" validate:"required,max=20000"`
+		}
+		if !bindJSON(c, &request) {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		message, err := ec.Messages.Get(ctx, objID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		if err != nil {
+			log.Println("Error retrieving message:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error sending reply"})
+			return
+		}
+		if message.Email == nil || *message.Email == "" {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Message has no email address to reply to"})
+			return
+		}
+
+		reply := models.Reply{
+			Reply_ID:   primitive.NewObjectID(),
+			Author_ID:  c.GetString("uid"),
+			To:         *message.Email,
+			Body:       request.Body,
+			Created_At: time.Now(),
+		}
+		if authorID, err := primitive.ObjectIDFromHex(reply.Author_ID); err == nil {
+			if author, err := ec.Users.Get(ctx, authorID); err == nil && author.Name != nil {
+				reply.Author_Name = *author.Name
+			}
+		}
+
+		// Later replies keep the subject of the conversation unless told
+		// otherwise, and each answers the one before it.
+		subject := request.Subject
+		root := ec.threadRoot(message)
+		reply.In_Reply_To = root
+		reply.References = []string{root}
+		for _, previous := range message.Thread {
+			if subject == "" {
+				subject = previous.Subject
+			}
+			reply.In_Reply_To = previous.Mail_ID
+			reply.References = append(reply.References, previous.Mail_ID)
+		}
+		if len(reply.References) > maxReferences {
+			recent := reply.References[len(reply.References)-maxReferences+1:]
+			reply.References = append([]string{root}, recent...)
+		}
+
+		data := contactData(message)
+		email, err := ec.Templates.Compose(ctx, mail.TemplateContactReply, "", mail.ReplyData{
+			Name:      data.Name,
+			Subject:   subject,
+			Body:      request.Body,
+			Author:    reply.Author_Name,
+			Reference: data.Reference,
+		})
+		if err != nil {
+			log.Println("Error rendering reply:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error sending reply"})
+			return
+		}
+		email.To = reply.To
+		email.Message_ID = message.Message_ID.Hex()
+		email.In_Reply_To = reply.In_Reply_To
+		email.References = reply.References
+		if err := ec.Outbox.Enqueue(ctx, email); err != nil {
+			log.Println("Error queueing reply:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error sending reply"})
+			return
+		}
+		reply.Subject = email.Subject
+		reply.Mail_ID = email.Mail_ID
+		reply.Outbound_ID = email.Outbound_ID
+		reply.Status = email.Status
+
+		if err := ec.Messages.AddReply(ctx, objID, reply); err != nil {
+			log.Println("Error recording reply:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Reply queued but could not be added to the thread"})
+			return
+		}
+		ec.auditMessage(c, ctx, objID, message)
+
+		c.JSON(http.StatusCreated, reply)
+	}
+}
+
+// fillDeliveryStatus lists the outbox entries queued about message in its
+// Deliveries, oldest first, and sets the delivery state of each reply in
+// the thread from its entry.
+func (ec *EmailController) fillDeliveryStatus(ctx context.Context, message *models.Message) error {
+	emails, err := ec.Outbox.Repo.List(ctx, bson.M{"message_id": message.Message_ID.Hex()}, query.List{
+		Sort: []query.SortField{{Field: "created_at"}},
+	})
+	if err != nil {
+		return err
+	}
+	message.Deliveries = make([]models.Delivery, 0, len(emails.Items))
+	sent := map[primitive.ObjectID]*models.OutboundEmail{}
+	for i, email := range emails.Items {
+		message.Deliveries = append(message.Deliveries, models.Delivery{
+			Outbound_ID: email.Outbound_ID,
+			Kind:        email.Kind,
+			To:          email.To,
+			Status:      email.Status,
+			Attempts:    email.Attempts,
+			Last_Error:  email.Last_Error,
+			Sent_At:     email.Sent_At,
+			Created_At:  email.Created_At,
+		})
+		sent[email.Outbound_ID] = &emails.Items[i]
+	}
+	for i := range message.Thread {
+		if email, ok := sent[message.Thread[i].Outbound_ID]; ok {
+			message.Thread[i].Status = email.Status
+			message.Thread[i].Attempts = email.Attempts
+			message.Thread[i].Last_Error = email.Last_Error
+		}
+	}
+	return nil
+}
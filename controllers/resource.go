@@ -0,0 +1,451 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"nanosoft/middleware"
+	"nanosoft/models"
+	"nanosoft/query"
+	"nanosoft/repository"
+	"nanosoft/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Resource provides the create/get/update/delete handlers shared by the
+// simple content types. T is the model and PT its pointer type, which is
+// inferred, so a resource is declared as NewResource[models.Service](...).
+type Resource[T any, PT interface {
+	*T
+	models.Document
+}] struct {
+	// Name is the singular, lower-case resource name used in routes and messages.
+	Name string
+	Repo repository.VersionedRepository[T]
+	// Fields lists the bson fields an update is allowed to write. The
+	// content models use the same names in JSON, which is how a patch
+	// refers to them.
+	Fields []string
+	List   query.Spec
+	// Files holds the uploaded images, which are deleted when a document
+	// is purged.
+	Files storage.Storage
+}
+
+func NewResource[T any, PT interface {
+	*T
+	models.Document
+}](name string, repo repository.VersionedRepository[T], fields []string, list query.Spec, files storage.Storage) *Resource[T, PT] {
+	return &Resource[T, PT]{Name: name, Repo: repo, Fields: fields, List: list, Files: files}
+}
+
+// Routes registers the standard /<name>/... routes: reads are public,
+// writes and the trash require an admin.
+func (r *Resource[T, PT]) Routes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup) {
+	publicRoutes.GET("/"+r.Name+"/get-all", r.GetAll())
+	publicRoutes.GET("/"+r.Name+"/get-one/:id", r.GetOne())
+
+	adminRoutes.POST("/"+r.Name+"/create", r.Create())
+	adminRoutes.PUT("/"+r.Name+"/update/:id", r.Update())
+	adminRoutes.PATCH("/"+r.Name+"/update/:id", r.Patch())
+	adminRoutes.DELETE("/"+r.Name+"/delete/:id", r.Delete())
+	adminRoutes.GET("/"+r.Name+"/trash", r.GetTrash())
+	adminRoutes.POST("/"+r.Name+"/restore/:id", r.Restore())
+	adminRoutes.DELETE("/"+r.Name+"/purge/:id", r.Purge())
+}
+
+func (r *Resource[T, PT]) title() string {
+	return strings.ToUpper(r.Name[:1]) + r.Name[1:]
+}
+
+func (r *Resource[T, PT]) Create() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var doc T
+		if !bindJSON(c, &doc) {
+			return
+		}
+
+		now := time.Now()
+		PT(&doc).SetID(primitive.NewObjectID())
+		PT(&doc).SetVersion(1)
+		PT(&doc).SetCreatedAt(now)
+		PT(&doc).SetUpdatedAt(now)
+
+		if err := r.Repo.Create(ctx, &doc); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating " + r.Name})
+			return
+		}
+		middleware.AuditResource(c, r.Name, PT(&doc).GetID().Hex())
+		middleware.AuditChange(c, nil, &doc)
+
+		c.JSON(http.StatusCreated, gin.H{"message": r.title() + " created successfully"})
+	}
+}
+
+func (r *Resource[T, PT]) Update() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + r.Name + " ID"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var doc T
+		if !bindJSON(c, &doc) {
+			return
+		}
+		existing, version, ok := r.current(c, ctx, objID)
+		if !ok {
+			return
+		}
+		PT(&doc).SetUpdatedAt(time.Now())
+
+		set, err := r.fieldValues(&doc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating " + r.Name, "details": err.Error()})
+			return
+		}
+
+		r.update(c, ctx, objID, version, set, existing)
+	}
+}
+
+// Patch changes only the fields present in the request body, which is a
+// JSON Merge Patch or, sent as application/json-patch+json, a JSON Patch.
+// Fields that an update may not write cannot be patched. The patch only
+// applies if the document is unchanged when it is written.
+func (r *Resource[T, PT]) Patch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + r.Name + " ID"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		existing, err := r.Repo.Get(ctx, objID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": r.title() + " not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error retrieving %s: %v", r.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating " + r.Name})
+			return
+		}
+
+		version := PT(existing).GetVersion()
+		if !ifMatch(c, versionETag(objID, version)) {
+			return
+		}
+
+		var doc T
+		fields, ok := applyPatch(c, existing, &doc, r.updatable)
+		if !ok {
+			return
+		}
+		if !validRequest(c, &doc) {
+			return
+		}
+		PT(&doc).SetUpdatedAt(time.Now())
+
+		all, err := r.fieldValues(&doc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating " + r.Name, "details": err.Error()})
+			return
+		}
+		set := bson.M{"updated_at": all["updated_at"]}
+		for _, field := range fields {
+			set[field] = all[field]
+		}
+
+		r.update(c, ctx, objID, &version, set, existing)
+	}
+}
+
+func (r *Resource[T, PT]) updatable(field string) bool {
+	for _, f := range r.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// current loads the document about to be changed and checks the If-Match
+// header against it. Without the header any version may be changed and the
+// version is nil.
+func (r *Resource[T, PT]) current(c *gin.Context, ctx context.Context, id primitive.ObjectID) (*T, *int64, bool) {
+	doc, err := r.Repo.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": r.title() + " not found"})
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Printf("Error retrieving %s: %v", r.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving " + r.Name})
+		return nil, nil, false
+	}
+	if c.GetHeader("If-Match") == "" {
+		return doc, nil, true
+	}
+	version := PT(doc).GetVersion()
+	return doc, &version, ifMatch(c, versionETag(id, version))
+}
+
+// update applies set to the document, provided it is still at version
+// unless that is nil, and answers with the result. The change from before
+// is recorded in the audit log and the files it no longer refers to are
+// deleted.
+func (r *Resource[T, PT]) update(c *gin.Context, ctx context.Context, id primitive.ObjectID, version *int64, set bson.M, before *T) {
+	var err error
+	if version == nil {
+		err = r.Repo.Update(ctx, id, set)
+	} else {
+		err = r.Repo.UpdateIf(ctx, id, *version, set)
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": r.title() + " not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": r.title() + " has been modified; reload it and try again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating " + r.Name, "details": err.Error()})
+		return
+	}
+	if after := r.respondOne(c, ctx, id); after != nil {
+		middleware.AuditChange(c, before, after)
+		storage.DeleteReplaced(ctx, r.Files, PT(before).Files(), PT(after).Files())
+	}
+}
+
+// respondOne answers with the document after a change to it, and returns
+// it unless it could not be loaded.
+func (r *Resource[T, PT]) respondOne(c *gin.Context, ctx context.Context, id primitive.ObjectID) *T {
+	doc, err := r.Repo.Get(ctx, id)
+	if err != nil {
+		log.Printf("Error retrieving %s: %v", r.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving " + r.Name})
+		return nil
+	}
+	c.Header("ETag", versionETag(id, PT(doc).GetVersion()))
+	c.JSON(http.StatusOK, doc)
+	return doc
+}
+
+// fieldValues returns the updatable fields of doc, plus updated_at, keyed
+// by their bson names.
+func (r *Resource[T, PT]) fieldValues(doc *T) (bson.M, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var all bson.M
+	if err := bson.Unmarshal(raw, &all); err != nil {
+		return nil, err
+	}
+	set := bson.M{"updated_at": all["updated_at"]}
+	for _, field := range r.Fields {
+		set[field] = all[field]
+	}
+	return set, nil
+}
+
+// Delete moves a document to the trash. The audit log records its
+// deleted_at and deleted_by being set.
+func (r *Resource[T, PT]) Delete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + r.Name + " ID"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		before, version, ok := r.current(c, ctx, objID)
+		if !ok {
+			return
+		}
+		if version == nil {
+			err = r.Repo.Trash(ctx, objID, c.GetString("uid"))
+		} else {
+			err = r.Repo.TrashIf(ctx, objID, *version, c.GetString("uid"))
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": r.title() + " not found"})
+			return
+		}
+		if errors.Is(err, repository.ErrConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": r.title() + " has been modified; reload it and try again"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting " + r.Name})
+			return
+		}
+		if after, err := r.Repo.GetTrashed(ctx, objID); err == nil {
+			middleware.AuditChange(c, before, after)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": r.title() + " moved to trash"})
+	}
+}
+
+// GetTrash lists the trashed documents.
+func (r *Resource[T, PT]) GetTrash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := query.Parse(c.Request.URL.Query(), r.List)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		page, err := r.Repo.ListTrash(ctx, bson.M{}, list)
+		if err != nil {
+			log.Printf("Error retrieving trashed %ss: %v", r.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving trash"})
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// Restore takes a document out of the trash.
+func (r *Resource[T, PT]) Restore() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + r.Name + " ID"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		before, err := r.Repo.GetTrashed(ctx, objID)
+		if err == nil {
+			err = r.Repo.Restore(ctx, objID)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": r.title() + " not found in trash"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error restoring %s: %v", r.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error restoring " + r.Name})
+			return
+		}
+
+		if after := r.respondOne(c, ctx, objID); after != nil {
+			middleware.AuditChange(c, before, after)
+		}
+	}
+}
+
+// Purge deletes a trashed document and its uploaded files permanently. The
+// audit log keeps the fields it had.
+func (r *Resource[T, PT]) Purge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + r.Name + " ID"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		trashed, err := r.Repo.GetTrashed(ctx, objID)
+		if err == nil {
+			err = r.Repo.Purge(ctx, objID)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": r.title() + " not found in trash"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error purging %s: %v", r.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting " + r.Name})
+			return
+		}
+		middleware.AuditChange(c, trashed, nil)
+		storage.DeleteAll(ctx, r.Files, PT(trashed).Files())
+
+		c.JSON(http.StatusOK, gin.H{"message": r.title() + " deleted permanently"})
+	}
+}
+
+func (r *Resource[T, PT]) GetAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := query.Parse(c.Request.URL.Query(), r.List)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		page, err := r.Repo.List(ctx, bson.M{}, list)
+		if err != nil {
+			log.Printf("Error retrieving %ss: %v", r.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving " + r.Name + "s"})
+			return
+		}
+
+		respondCached(c, page)
+	}
+}
+
+func (r *Resource[T, PT]) GetOne() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + r.Name + " ID"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		doc, err := r.Repo.Get(ctx, objID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": r.title() + " not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error retrieving %s: %v", r.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving " + r.Name, "details": err.Error()})
+			return
+		}
+		if notModified(c, versionETag(objID, PT(doc).GetVersion())) {
+			return
+		}
+
+		c.JSON(http.StatusOK, doc)
+	}
+}
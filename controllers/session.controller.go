@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"nanosoft/middleware"
+	generate "nanosoft/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Logout ends the session the current access token belongs to. The refresh
+// token family is revoked and the family ID is denylisted, which also rejects
+// every access token issued from it.
+func (uc *UserController) Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		now := time.Now()
+		if familyID := c.GetString("sid"); familyID != "" {
+			if err := uc.Sessions.RevokeFamily(ctx, familyID, now); err != nil {
+				log.Println("Error revoking session family:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+				return
+			}
+			if err := uc.Revocations.Revoke(ctx, familyID, "logout", now.Add(generate.AccessTokenTTL())); err != nil {
+				log.Println("Error denylisting session family:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+				return
+			}
+		}
+
+		if tokenID := c.GetString("jti"); tokenID != "" {
+			expiresAt := time.Unix(c.GetInt64("token_expires_at"), 0)
+			if err := uc.Revocations.Revoke(ctx, tokenID, "logout", expiresAt); err != nil {
+				log.Println("Error denylisting access token:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+	}
+}
+
+// LogoutAll ends every session of the current user.
+func (uc *UserController) LogoutAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := uc.revokeUserSessions(ctx, c.GetString("uid"), "logout all"); err != nil {
+			log.Println("Error revoking user sessions:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+	}
+}
+
+// RevokeUserSessions lets an admin end every session of the user in the path.
+func (uc *UserController) RevokeUserSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		middleware.AuditResource(c, "user", userID.Hex())
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := uc.revokeUserSessions(ctx, userID.Hex(), "revoked by admin"); err != nil {
+			log.Println("Error revoking user sessions:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked successfully"})
+	}
+}
+
+// revokeUserSessions revokes all active sessions of userID and denylists
+// their families for as long as an access token issued from them can live.
+func (uc *UserController) revokeUserSessions(ctx context.Context, userID, reason string) error {
+	now := time.Now()
+	families, err := uc.Sessions.RevokeUser(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+	expiresAt := now.Add(generate.AccessTokenTTL())
+	for _, familyID := range families {
+		if err := uc.Revocations.Revoke(ctx, familyID, reason, expiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"nanosoft/mail"
+	"nanosoft/middleware"
+	"nanosoft/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TemplateController lets admins view and edit the email templates. Edits
+// are stored in the repository and take precedence over the templates on
+// disk until they are reset. Every route takes an optional locale query
+// parameter to work on a translation instead.
+type TemplateController struct {
+	Templates *mail.Templates
+}
+
+func NewTemplateController(templates *mail.Templates) *TemplateController {
+	return &TemplateController{Templates: templates}
+}
+
+type templateRequest struct {
+	Subject string `json:"subject" validate:"required"`
+	HTML    string `json:"html" validate:"required"`
+	Text    string `json:"text" validate:"required"`
+}
+
+// GetAllTemplates lists every template as currently in effect.
+func (tc *TemplateController) GetAllTemplates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		locale, ok := templateLocale(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		templates := []*mail.Source{}
+		for _, name := range mail.TemplateNames() {
+			src, err := tc.Templates.Source(ctx, name, locale)
+			if err != nil {
+				log.Println("Error loading email template:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving templates"})
+				return
+			}
+			templates = append(templates, src)
+		}
+
+		c.JSON(http.StatusOK, templates)
+	}
+}
+
+// GetTemplate returns one template and its rendering with sample data.
+func (tc *TemplateController) GetTemplate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		locale, ok := templateLocale(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		src, err := tc.Templates.Source(ctx, c.Param("name"), locale)
+		if errors.Is(err, mail.ErrUnknownTemplate) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		if err != nil {
+			log.Println("Error loading email template:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving template"})
+			return
+		}
+		preview, err := mail.Preview(src)
+		if err != nil {
+			// A template on disk may be broken; show it so it can be fixed.
+			c.JSON(http.StatusOK, gin.H{"template": src, "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"template": src, "preview": preview})
+	}
+}
+
+// UpdateTemplate replaces a template. It is rejected unless every part
+// parses and renders with the sample data.
+func (tc *TemplateController) UpdateTemplate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		middleware.AuditResource(c, "template", c.Param("name"))
+		src, ok := bindTemplate(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err := tc.Templates.Save(ctx, src, c.GetString("uid"))
+		if errors.Is(err, mail.ErrUnknownTemplate) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		if err != nil {
+			var templateErr *mail.TemplateError
+			if errors.As(err, &templateErr) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			log.Println("Error saving email template:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving template"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Template updated successfully"})
+	}
+}
+
+// ResetTemplate drops an admin's edit so the default template applies again.
+func (tc *TemplateController) ResetTemplate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		middleware.AuditResource(c, "template", c.Param("name"))
+		locale, ok := templateLocale(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err := tc.Templates.Reset(ctx, c.Param("name"), locale)
+		if errors.Is(err, mail.ErrUnknownTemplate) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Println("Error resetting email template:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting template"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Template reset to default"})
+	}
+}
+
+// PreviewTemplate renders a template from the request body with sample data
+// without saving it.
+func (tc *TemplateController) PreviewTemplate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		middleware.AuditResource(c, "template", c.Param("name"))
+		src, ok := bindTemplate(c)
+		if !ok {
+			return
+		}
+
+		preview, err := mail.Preview(src)
+		if errors.Is(err, mail.ErrUnknownTemplate) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, preview)
+	}
+}
+
+// templateLocale reads the locale query parameter, answering 400 if it is
+// not a language tag.
+func templateLocale(c *gin.Context) (string, bool) {
+	locale := c.Query("locale")
+	if locale == "" {
+		return "", true
+	}
+	locale, ok := mail.NormalizeLocale(locale)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid locale"})
+	}
+	return locale, ok
+}
+
+func bindTemplate(c *gin.Context) (*mail.Source, bool) {
+	locale, ok := templateLocale(c)
+	if !ok {
+		return nil, false
+	}
+	var request templateRequest
+	if !bindJSON(c, &request) {
+		return nil, false
+	}
+	return &mail.Source{Name: c.Param("name"), Locale: locale, Subject: request.Subject, HTML: request.HTML, Text: request.Text}, true
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"nanosoft/config"
+	"nanosoft/models"
+	"nanosoft/repository"
+	"nanosoft/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UploadController stores uploaded images and serves stored files. An
+// upload answers with the image and image_path pair the models keep, so
+// the client can put it straight into a document.
+type UploadController struct {
+	Files storage.Storage
+	Users repository.UserRepository
+	// MaxSize is the largest file accepted, in bytes.
+	MaxSize int64
+}
+
+func NewUploadController(store *repository.Store, files storage.Storage) *UploadController {
+	return &UploadController{
+		Files:   files,
+		Users:   store.Users,
+		MaxSize: int64(config.Int("UPLOAD_MAX_BYTES", 5<<20)),
+	}
+}
+
+// imageTypes maps the image types that can be uploaded to the extension
+// they are stored with. SVG is left out as it can carry scripts.
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// multipartOverhead is how much larger than the file a request may be, for
+// the multipart headers and boundaries.
+const multipartOverhead = 64 << 10
+
+// receiveImage stores the image sent in the file field of a multipart
+// request under dir and returns its path. The type is sniffed from the
+// content; the name and Content-Type the client sent are ignored. On
+// failure it answers 400, 413 or 415 and reports false.
+func (uc *UploadController) receiveImage(c *gin.Context, ctx context.Context, dir string) (string, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, uc.MaxSize+multipartOverhead)
+	header, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || (err == nil && header.Size > uc.MaxSize) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File must be at most " + strconv.FormatInt(uc.MaxSize, 10) + " bytes"})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Send the file as multipart/form-data in the file field"})
+		return "", false
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		log.Println("Error opening uploaded file:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the uploaded file"})
+		return "", false
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The uploaded file is empty"})
+		return "", false
+	}
+	ext, ok := imageTypes[http.DetectContentType(head[:n])]
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Only JPEG, PNG, GIF and WebP images can be uploaded"})
+		return "", false
+	}
+
+	name := path.Join(dir, primitive.NewObjectID().Hex()+ext)
+	content := io.MultiReader(bytes.NewReader(head[:n]), io.LimitReader(file, uc.MaxSize-int64(n)))
+	if err := uc.Files.Save(ctx, name, content); err != nil {
+		log.Println("Error storing uploaded file:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error storing file"})
+		return "", false
+	}
+	return name, true
+}
+
+// UploadImage stores an image for a service, project, remark or post.
+func (uc *UploadController) UploadImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		name, ok := uc.receiveImage(c, ctx, "images/"+time.Now().UTC().Format("2006/01"))
+		if !ok {
+			return
+		}
+
+		url := uc.Files.URL(name)
+		c.JSON(http.StatusCreated, models.Images{Image: &url, ImagePath: &name})
+	}
+}
+
+// UploadAvatar replaces the current user's avatar with the uploaded image
+// and deletes the previous one if it was uploaded too.
+func (uc *UploadController) UploadAvatar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := uc.Users.FindByEmail(ctx, c.GetString("email"))
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Println("Error retrieving user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving user"})
+			return
+		}
+
+		name, ok := uc.receiveImage(c, ctx, models.AvatarDir(user.User_ID))
+		if !ok {
+			return
+		}
+		url := uc.Files.URL(name)
+		if err := uc.Users.Update(ctx, user.ID, bson.M{"avatar": url, "avatar_path": name}); err != nil {
+			log.Println("Error updating avatar:", err)
+			storage.DeleteAll(ctx, uc.Files, []string{name})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating avatar"})
+			return
+		}
+		storage.DeleteAll(ctx, uc.Files, user.Files())
+
+		c.JSON(http.StatusOK, gin.H{"avatar": url, "avatar_path": name})
+	}
+}
+
+// ServeFile answers with a stored file. Stored names are never reused, so
+// the file can be cached indefinitely.
+func (uc *UploadController) ServeFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, err := storage.CleanPath(c.Param("path")[1:])
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		file, err := uc.Files.Open(ctx, name)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		if err != nil {
+			log.Println("Error opening stored file:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving file"})
+			return
+		}
+		defer file.Close()
+
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("X-Content-Type-Options", "nosniff")
+		if seeker, ok := file.(io.ReadSeeker); ok {
+			http.ServeContent(c.Writer, c.Request, path.Base(name), time.Time{}, seeker)
+			return
+		}
+		c.DataFromReader(http.StatusOK, -1, mime.TypeByExtension(path.Ext(name)), file, nil)
+	}
+}
@@ -2,33 +2,68 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
-	"nanosoft/database"
+	"nanosoft/background"
+	"nanosoft/mail"
+	"nanosoft/middleware"
 	"nanosoft/models"
+	"nanosoft/query"
+	"nanosoft/repository"
+	"nanosoft/storage"
 	generate "nanosoft/tokens"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var UserCollection *mongo.Collection = database.UserData(database.Client, "Users")
+type UserController struct {
+	Users       repository.UserRepository
+	Sessions    repository.SessionRepository
+	Revocations repository.RevocationRepository
+	Resets      repository.PasswordResetRepository
+	Attempts    repository.LoginAttemptRepository
+	Lockouts    repository.LockoutRepository
+	Policy      LoginPolicy
+	Outbox      *mail.Outbox
+	Templates   *mail.Templates
+	// Workers runs lookups whose duration must not show in the response.
+	Workers *background.Group
+	// Files holds uploaded avatars, which are deleted with their user or
+	// when it no longer uses them.
+	Files storage.Storage
+}
 
-var Validate = validator.New()
+// NewUserController takes the repositories it needs from store and its
+// login policy from the environment.
+func NewUserController(store *repository.Store, outbox *mail.Outbox, templates *mail.Templates, workers *background.Group, files storage.Storage) *UserController {
+	return &UserController{
+		Users:       store.Users,
+		Sessions:    store.Sessions,
+		Revocations: store.Revocations,
+		Resets:      store.Resets,
+		Attempts:    store.Attempts,
+		Lockouts:    store.Lockouts,
+		Policy:      LoadLoginPolicy(),
+		Outbox:      outbox,
+		Templates:   templates,
+		Workers:     workers,
+		Files:       files,
+	}
+}
 
-func HashPassword(password string) string {
+func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
-		log.Panic(err)
+		return "", err
 	}
-	return string(bytes)
+	return string(bytes), nil
 }
 
 func VerifyPassword(hashedPassword string, plainPassword string) (bool, string) {
@@ -42,85 +77,133 @@ func VerifyPassword(hashedPassword string, plainPassword string) (bool, string)
 	return valid, msg
 }
 
-func Register() gin.HandlerFunc {
+func (uc *UserController) Register() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 		var user models.User
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if !bindJSON(c, &user) {
+			return
+		}
+
+		_, err := uc.Users.FindByEmail(ctx, *user.Email)
+		if err == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists"})
 			return
 		}
-		validationErr := Validate.Struct(user)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr})
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Println("Error checking for existing user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-
-		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+		// A deleted account keeps its address until it is purged, so it
+		// can still be restored.
+		trashed, err := uc.Users.ListTrash(ctx, bson.M{"email": *user.Email}, query.List{Limit: 1})
 		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			log.Println("Error checking for existing user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		if count > 0 {
+		if trashed.Total > 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists"})
+			return
+		}
+		password, err := HashPassword(*user.Password)
+		if err != nil {
+			log.Println("Error hashing password:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "not created"})
+			return
 		}
-		password := HashPassword(*user.Password)
 		user.Password = &password
 
 		user.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.User_ID = user.ID.Hex()
+		user.Version = 1
 
 		role := 0
+		verified := false
 		user.Role = role
-		token, refreshtoken, _ := generate.TokenGenerator(*user.Email, *user.Name, user.User_ID, user.Role)
-		user.Token = &token
-		user.Refresh_Token = &refreshtoken
-		_, inserterr := UserCollection.InsertOne(ctx, user)
+		user.Email_Verified = &verified
+		user.Verified_At = nil
+		user.Token = nil
+		user.Refresh_Token = nil
+		inserterr := uc.Users.Create(ctx, &user)
 		if inserterr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "not created"})
 			return
 		}
+		if err := uc.sendVerification(ctx, &user); err != nil {
+			log.Println("Error queueing verification email:", err)
+		}
 		defer cancel()
 		c.JSON(http.StatusCreated, "Successfully Registered!!")
 	}
 }
 
-func Login() gin.HandlerFunc {
+func (uc *UserController) Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 		var user models.User
-		var founduser models.User
 		if err := c.BindJSON(&user); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err})
 			return
 		}
-		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&founduser)
-		defer cancel()
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+		// Checked before bcrypt runs, so a locked out attacker costs us
+		// no hashing.
+		wait, err := uc.loginBlockedFor(ctx, accountKey(*user.Email), ipKey(c.ClientIP()))
+		if err != nil {
+			log.Println("Error checking login lockout:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+			return
+		}
+		if wait > 0 {
+			tooManyLoginAttempts(c, wait)
+			return
+		}
+		founduser, err := uc.Users.FindByEmail(ctx, *user.Email)
 		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				uc.recordLoginFailure(ctx, c, *user.Email, nil)
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "login or password incorrect"})
 			return
 		}
 		PasswordIsValid, msg := VerifyPassword(*founduser.Password, *user.Password)
 		defer cancel()
 		if !PasswordIsValid {
+			uc.recordLoginFailure(ctx, c, *user.Email, founduser)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			fmt.Println(msg)
 			return
 		}
-		token, refreshToken, _ := generate.TokenGenerator(*founduser.Email, *founduser.Name, founduser.User_ID, founduser.Role)
-		defer cancel()
-		generate.UpdateAllTokens(token, refreshToken, founduser.User_ID)
+		if err := uc.Attempts.Clear(ctx, accountKey(*user.Email)); err != nil {
+			log.Println("Error clearing login failures:", err)
+		}
+		if !founduser.IsVerified() && generate.VerificationPolicy() == generate.VerifyLogin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address has not been verified"})
+			return
+		}
+		token, refreshToken, err := uc.issueTokens(ctx, c, founduser, "")
+		if err != nil {
+			log.Println("Error issuing tokens:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not generate tokens"})
+			return
+		}
+		founduser.Token = &token
+		founduser.Refresh_Token = &refreshToken
 
 		c.JSON(http.StatusFound, founduser)
 	}
 }
 
-func RefreshToken() gin.HandlerFunc {
+func (uc *UserController) RefreshToken() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		refreshToken := c.Query("refreshToken")
 		if refreshToken == "" {
@@ -136,13 +219,64 @@ func RefreshToken() gin.HandlerFunc {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
 			return
 		}
+		if claims.Type != generate.RefreshToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Refresh Token"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		session, err := uc.Sessions.FindByHash(ctx, generate.HashToken(refreshToken))
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Refresh Token"})
+			return
+		}
+		if err != nil {
+			log.Println("Error finding session:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not generate new tokens"})
+			return
+		}
+		if session.Revoked_At != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has been revoked"})
+			return
+		}
+
+		now := time.Now()
+		err = uc.Sessions.MarkRotated(ctx, session.Session_ID, now)
+		if session.Rotated_At != nil || errors.Is(err, repository.ErrNotFound) {
+			// The token was already exchanged, so it has leaked or been
+			// replayed: end every session descended from the same login.
+			log.Printf("Refresh token reuse detected for user %s, revoking family %s", session.User_ID, session.Family_ID)
+			if err := uc.Sessions.RevokeFamily(ctx, session.Family_ID, now); err != nil {
+				log.Println("Error revoking session family:", err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has already been used"})
+			return
+		}
+		if err != nil {
+			log.Println("Error rotating session:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not generate new tokens"})
+			return
+		}
+
+		userID, _ := primitive.ObjectIDFromHex(session.User_ID)
+		user, err := uc.Users.Get(ctx, userID)
+		if err != nil {
+			log.Println("Error loading user for refresh:", err)
+			if err := uc.Sessions.RevokeFamily(ctx, session.Family_ID, now); err != nil {
+				log.Println("Error revoking session family:", err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Refresh Token"})
+			return
+		}
 
-		newAccessToken, newRefreshToken, err := generate.TokenGenerator(claims.Email, claims.Name, claims.Uid, claims.Role)
+		newAccessToken, newRefreshToken, err := uc.issueTokens(ctx, c, user, session.Family_ID)
 		if err != nil {
+			log.Println("Error issuing tokens:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not generate new tokens"})
 			return
 		}
-		generate.UpdateAllTokens(newAccessToken, newRefreshToken, claims.Uid)
 
 		c.JSON(http.StatusOK, gin.H{
 			"access_token":  newAccessToken,
@@ -151,7 +285,37 @@ func RefreshToken() gin.HandlerFunc {
 	}
 }
 
-func GetUserInfo() gin.HandlerFunc {
+// issueTokens signs a token pair for user and stores the refresh token's
+// hash as a new session. An empty familyID starts a new family, as on login.
+func (uc *UserController) issueTokens(ctx context.Context, c *gin.Context, user *models.User, familyID string) (string, string, error) {
+	sessionID := primitive.NewObjectID()
+	if familyID == "" {
+		familyID = sessionID.Hex()
+	}
+
+	token, refreshToken, err := generate.TokenGenerator(*user.Email, *user.Name, user.User_ID, user.Role, user.IsVerified(), familyID, sessionID.Hex())
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		Session_ID: sessionID,
+		Family_ID:  familyID,
+		User_ID:    user.User_ID,
+		Token_Hash: generate.HashToken(refreshToken),
+		User_Agent: c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		Expires_At: now.Add(generate.RefreshTokenTTL()),
+		Created_At: now,
+	}
+	if err := uc.Sessions.Create(ctx, &session); err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+func (uc *UserController) GetUserInfo() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userEmail, ok := c.Get("email")
 		if !ok {
@@ -170,30 +334,39 @@ func GetUserInfo() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var foundUser models.User
-		err := UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "email", Value: emailStr}}).Decode(&foundUser)
+		foundUser, err := uc.Users.FindByEmail(ctx, emailStr)
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(http.StatusInternalServerError, "invalid token")
 			return
 		}
+		if notModified(c, versionETag(foundUser.ID, foundUser.Version)) {
+			return
+		}
 
 		c.JSON(http.StatusOK, foundUser)
 	}
 }
 
-func UpdateUserInfo() gin.HandlerFunc {
+func (uc *UserController) UpdateUserInfo() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var userInfo struct {
-			Name       string `json:"name"`
-			Avatar     string `json:"avatar"`
-			AvatarPath string `json:"avatar_path"`
+			Name       string  `json:"name" validate:"required,min=2,max=30"`
+			Avatar     *string `json:"avatar" validate:"omitempty,url"`
+			AvatarPath *string `json:"avatar_path" validate:"omitempty,max=500"`
 		}
 
-		// Log the incoming request body
-		if err := c.ShouldBindJSON(&userInfo); err != nil {
-			log.Println("Invalid request body:", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		if !bindJSON(c, &userInfo) {
+			return
+		}
+		// Only an avatar uploaded for this user may be referred to, since
+		// the file at avatar_path is deleted along with the user.
+		if userInfo.AvatarPath != nil && *userInfo.AvatarPath != "" && !models.IsAvatarPath(c.GetString("uid"), *userInfo.AvatarPath) {
+			respondInvalid(c, []FieldError{{
+				Field:   "avatar_path",
+				Code:    "upload",
+				Message: "avatar_path must be a path returned by /user/avatar",
+			}})
 			return
 		}
 		log.Println("Received user info:", userInfo)
@@ -215,54 +388,66 @@ func UpdateUserInfo() gin.HandlerFunc {
 		}
 		log.Println("Updating user with email:", emailStr)
 
-		update := bson.M{
-			"$set": bson.M{
-				"name":        userInfo.Name,
-				"avatar":      userInfo.Avatar,
-				"avatar_path": userInfo.AvatarPath,
-			},
+		// The avatar is only changed when the request sets it.
+		update := bson.M{"name": userInfo.Name}
+		if userInfo.Avatar != nil {
+			update["avatar"] = *userInfo.Avatar
 		}
-
-		result, err := UserCollection.UpdateOne(ctx, bson.M{"email": emailStr}, update)
-		if err != nil {
-			log.Println("Failed to update user info:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user info"})
-			return
+		if userInfo.AvatarPath != nil {
+			update["avatar_path"] = *userInfo.AvatarPath
 		}
 
-		if result.MatchedCount == 0 {
+		user, err := uc.Users.FindByEmail(ctx, emailStr)
+		if err == nil {
+			if c.GetHeader("If-Match") == "" {
+				err = uc.Users.Update(ctx, user.ID, update)
+			} else {
+				if !ifMatch(c, versionETag(user.ID, user.Version)) {
+					return
+				}
+				err = uc.Users.UpdateIf(ctx, user.ID, user.Version, update)
+			}
+		}
+		if errors.Is(err, repository.ErrNotFound) {
 			log.Println("User not found with email:", emailStr)
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
+		if errors.Is(err, repository.ErrConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has been modified; reload it and try again"})
+			return
+		}
+		if err != nil {
+			log.Println("Failed to update user info:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user info"})
+			return
+		}
 
-		var updatedUser models.User
-		err = UserCollection.FindOne(ctx, bson.M{"email": emailStr}).Decode(&updatedUser)
+		updatedUser, err := uc.Users.FindByEmail(ctx, emailStr)
 		if err != nil {
 			log.Println("Error retrieving updated user info:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving updated user info"})
 			return
 		}
+		storage.DeleteReplaced(ctx, uc.Files, user.Files(), updatedUser.Files())
 
 		log.Println("Updated user info:", updatedUser)
+		c.Header("ETag", versionETag(updatedUser.ID, updatedUser.Version))
 		c.JSON(http.StatusOK, updatedUser)
 	}
 }
 
-func UpdateUserPassword() gin.HandlerFunc {
+func (uc *UserController) UpdateUserPassword() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var passwordUpdate struct {
-			OldPassword string `json:"old_password"`
-			NewPassword string `json:"new_password"`
+			OldPassword string `json:"old_password" validate:"required"`
+			NewPassword string `json:"new_password" validate:"required,min=6,maxbytes=72"`
 		}
 
-		if err := c.ShouldBindJSON(&passwordUpdate); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		if !bindJSON(c, &passwordUpdate) {
 			return
 		}
 
-		log.Println(passwordUpdate)
-
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
@@ -279,14 +464,14 @@ func UpdateUserPassword() gin.HandlerFunc {
 			return
 		}
 
-		var foundUser models.User
-		err := UserCollection.FindOne(ctx, bson.M{"email": emailStr}).Decode(&foundUser)
+		foundUser, err := uc.Users.FindByEmail(ctx, emailStr)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
 			return
 		}
-
-		log.Println(foundUser.Password)
+		if !ifMatch(c, versionETag(foundUser.ID, foundUser.Version)) {
+			return
+		}
 
 		PasswordIsValid, msg := VerifyPassword(*foundUser.Password, passwordUpdate.OldPassword)
 		if !PasswordIsValid {
@@ -295,12 +480,24 @@ func UpdateUserPassword() gin.HandlerFunc {
 			return
 		}
 
-		newPasswordHash := HashPassword(passwordUpdate.NewPassword)
+		newPasswordHash, err := HashPassword(passwordUpdate.NewPassword)
+		if err != nil {
+			log.Println("Error hashing password:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+			return
+		}
 		foundUser.Password = &newPasswordHash
 
-		_, err = UserCollection.UpdateOne(ctx, bson.M{"email": emailStr}, bson.M{
-			"$set": bson.M{"password": newPasswordHash},
-		})
+		update := bson.M{"password": newPasswordHash}
+		if c.GetHeader("If-Match") == "" {
+			err = uc.Users.Update(ctx, foundUser.ID, update)
+		} else {
+			err = uc.Users.UpdateIf(ctx, foundUser.ID, foundUser.Version, update)
+		}
+		if errors.Is(err, repository.ErrConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has been modified; reload it and try again"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 			return
@@ -310,38 +507,46 @@ func UpdateUserPassword() gin.HandlerFunc {
 	}
 }
 
-func GetAllUsers() gin.HandlerFunc {
+var userListSpec = query.Spec{
+	Sortable: []string{"name", "email", "role", "created_at", "updated_at"},
+	Filterable: map[string]query.Kind{
+		"name":       query.String,
+		"email":      query.String,
+		"role":       query.Int,
+		"created_at": query.Time,
+	},
+}
+
+func (uc *UserController) GetAllUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		list, err := query.Parse(c.Request.URL.Query(), userListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var users []models.User
-		cursor, err := UserCollection.Find(ctx, bson.M{})
+		users, err := uc.Users.List(ctx, bson.M{}, list)
 		if err != nil {
-			log.Println("Error finding users:", err)
+			log.Println("Error retrieving users:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving users"})
 			return
 		}
 
-		if err = cursor.All(ctx, &users); err != nil {
-			log.Println("Error decoding users:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding users"})
-			return
-		}
-
-		c.JSON(http.StatusOK, users)
+		respondCached(c, users)
 	}
 }
 
-func UpdateUserRole() gin.HandlerFunc {
+func (uc *UserController) UpdateUserRole() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var roleUpdate struct {
-			UserID string `json:"user_id"`
-			Role   int    `json:"role"`
+			UserID string `json:"user_id" validate:"required"`
+			Role   int    `json:"role" validate:"oneof=0 1 2"`
 		}
 
-		if err := c.ShouldBindJSON(&roleUpdate); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		if !bindJSON(c, &roleUpdate) {
 			return
 		}
 
@@ -354,21 +559,35 @@ func UpdateUserRole() gin.HandlerFunc {
 			return
 		}
 
-		update := bson.M{
-			"$set": bson.M{
-				"role": roleUpdate.Role,
-			},
+		middleware.AuditResource(c, "user", userID.Hex())
+		user, version, ok := uc.currentUser(c, ctx, userID)
+		if !ok {
+			return
+		}
+		if version == nil {
+			err = uc.Users.Update(ctx, userID, bson.M{"role": roleUpdate.Role})
+		} else {
+			err = uc.Users.UpdateIf(ctx, userID, *version, bson.M{"role": roleUpdate.Role})
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if errors.Is(err, repository.ErrConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has been modified; reload it and try again"})
+			return
 		}
-
-		result, err := UserCollection.UpdateOne(ctx, bson.M{"_id": userID}, update)
 		if err != nil {
 			log.Println("Failed to update user role:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
 			return
 		}
+		middleware.AuditChange(c, gin.H{"role": user.Role}, gin.H{"role": roleUpdate.Role})
 
-		if result.MatchedCount == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		// Tokens carry the role, so the old ones must stop working now.
+		if err := uc.revokeUserSessions(ctx, userID.Hex(), "role changed"); err != nil {
+			log.Println("Failed to revoke sessions after role change:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "User role updated but sessions could not be revoked"})
 			return
 		}
 
@@ -376,7 +595,7 @@ func UpdateUserRole() gin.HandlerFunc {
 	}
 }
 
-func DeleteUser() gin.HandlerFunc {
+func (uc *UserController) DeleteUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.Param("id")
 		if userID == "" {
@@ -393,18 +612,152 @@ func DeleteUser() gin.HandlerFunc {
 			return
 		}
 
-		result, err := UserCollection.DeleteOne(ctx, bson.M{"_id": objID})
+		middleware.AuditResource(c, "user", userID)
+		before, version, ok := uc.currentUser(c, ctx, objID)
+		if !ok {
+			return
+		}
+		if version == nil {
+			err = uc.Users.Trash(ctx, objID, c.GetString("uid"))
+		} else {
+			err = uc.Users.TrashIf(ctx, objID, *version, c.GetString("uid"))
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if errors.Is(err, repository.ErrConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has been modified; reload it and try again"})
+			return
+		}
 		if err != nil {
 			log.Println("Failed to delete user:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 			return
 		}
+		if after, err := uc.Users.GetTrashed(ctx, objID); err == nil {
+			middleware.AuditChange(c, before, after)
+		}
 
-		if result.DeletedCount == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		if err := uc.revokeUserSessions(ctx, objID.Hex(), "user deleted"); err != nil {
+			log.Println("Failed to revoke sessions of deleted user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "User deleted but sessions could not be revoked"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "User moved to trash"})
+	}
+}
+
+// GetTrashedUsers lists the users in the trash.
+func (uc *UserController) GetTrashedUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := query.Parse(c.Request.URL.Query(), userListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		users, err := uc.Users.ListTrash(ctx, bson.M{}, list)
+		if err != nil {
+			log.Println("Error retrieving trashed users:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving trash"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+		c.JSON(http.StatusOK, users)
+	}
+}
+
+// RestoreUser takes a user out of the trash. Their sessions were revoked
+// when they were deleted, so they must log in again.
+func (uc *UserController) RestoreUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		middleware.AuditResource(c, "user", objID.Hex())
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		before, err := uc.Users.GetTrashed(ctx, objID)
+		if err == nil {
+			err = uc.Users.Restore(ctx, objID)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found in trash"})
+			return
+		}
+		if err != nil {
+			log.Println("Failed to restore user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore user"})
+			return
+		}
+		if after, err := uc.Users.Get(ctx, objID); err == nil {
+			middleware.AuditChange(c, before, after)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "User restored successfully"})
+	}
+}
+
+// PurgeUser deletes a trashed user and their uploaded avatar permanently.
+func (uc *UserController) PurgeUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		middleware.AuditResource(c, "user", objID.Hex())
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		trashed, err := uc.Users.GetTrashed(ctx, objID)
+		if err == nil {
+			err = uc.Users.Purge(ctx, objID)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found in trash"})
+			return
+		}
+		if err != nil {
+			log.Println("Failed to purge user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+			return
+		}
+		middleware.AuditChange(c, trashed, nil)
+		storage.DeleteAll(ctx, uc.Files, trashed.Files())
+
+		c.JSON(http.StatusOK, gin.H{"message": "User deleted permanently"})
+	}
+}
+
+// currentUser loads the user with id and checks the If-Match header
+// against it. Without the header any version may be changed and the
+// version is nil.
+func (uc *UserController) currentUser(c *gin.Context, ctx context.Context, id primitive.ObjectID) (*models.User, *int64, bool) {
+	user, err := uc.Users.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Println("Error retrieving user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving user"})
+		return nil, nil, false
+	}
+	if c.GetHeader("If-Match") == "" {
+		return user, nil, true
 	}
+	return user, &user.Version, ifMatch(c, versionETag(id, user.Version))
 }
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-playground/validator/v10/non-standard/validators"
+)
+
+// Validate checks the validate tags of models and request bodies. Errors
+// name fields by their JSON names, so they match what the client sent.
+var Validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "" && field.Anonymous {
+			return embedded
+		}
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	if err := v.RegisterValidation("notblank", validators.NotBlank); err != nil {
+		panic(err)
+	}
+	if err := v.RegisterValidation("maxbytes", maxBytes); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// maxBytes checks that a string is at most param bytes long, where max
+// counts characters. Passwords need it, as bcrypt refuses more than 72
+// bytes.
+func maxBytes(fl validator.FieldLevel) bool {
+	n, err := strconv.Atoi(fl.Param())
+	if err != nil {
+		panic("maxbytes: invalid param " + fl.Param())
+	}
+	return len(fl.Field().String()) <= n
+}
+
+// embedded stands in for the name of an embedded struct, whose fields
+// encoding/json reads as if they belonged to the outer one.
+const embedded = "^"
+
+// FieldError describes one invalid field of a request body. Code is the
+// rule that failed, such as "required", "max" or "url", and Param its
+// argument, if any. Field is a path such as "images[0].image".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// bindJSON decodes the request body into dst and validates it. On failure
+// it answers 400 and reports false; the body then has an error message
+// and, when specific fields are at fault, a FieldError for each of them.
+func bindJSON(c *gin.Context, dst interface{}) bool {
+	if err := c.ShouldBindJSON(dst); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			respondInvalid(c, []FieldError{typeError(typeErr)})
+			return false
+		}
+		if errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Request body is empty"})
+			return false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return false
+	}
+	return validRequest(c, dst)
+}
+
+// validRequest validates v, answering 400 with the failing fields if it is
+// invalid.
+func validRequest(c *gin.Context, v interface{}) bool {
+	err := Validate.Struct(v)
+	if err == nil {
+		return true
+	}
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return false
+	}
+	respondInvalid(c, fieldErrors(invalid))
+	return false
+}
+
+func respondInvalid(c *gin.Context, fields []FieldError) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "fields": fields})
+}
+
+func fieldErrors(invalid validator.ValidationErrors) []FieldError {
+	fields := make([]FieldError, 0, len(invalid))
+	for _, fe := range invalid {
+		// The namespace starts with the name of the validated struct.
+		field := fe.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+		field = strings.ReplaceAll(field, embedded+".", "")
+		fields = append(fields, FieldError{
+			Field:   field,
+			Code:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: field + " " + ruleMessage(fe),
+		})
+	}
+	return fields
+}
+
+// ruleMessage explains a failed rule, completing a sentence that starts
+// with the field name.
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "notblank":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "url":
+		return "must be a valid URL"
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	case "min", "max":
+		bound := "at least "
+		if fe.Tag() == "max" {
+			bound = "at most "
+		}
+		switch fe.Kind() {
+		case reflect.String:
+			return "must be " + bound + fe.Param() + " characters long"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return "must have " + bound + fe.Param() + " items"
+		}
+		return "must be " + bound + fe.Param()
+	case "maxbytes":
+		return "must be at most " + fe.Param() + " bytes long"
+	}
+	return "is invalid"
+}
+
+// typeError reports a JSON value of the wrong type for its field.
+func typeError(err *json.UnmarshalTypeError) FieldError {
+	kind := jsonKind(err.Type)
+	return FieldError{
+		Field:   err.Field,
+		Code:    "type",
+		Param:   kind,
+		Message: err.Field + " must be of type " + kind,
+	}
+}
+
+// jsonKind names the JSON type a Go value is decoded from.
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
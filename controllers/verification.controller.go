@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"nanosoft/config"
+	"nanosoft/mail"
+	"nanosoft/models"
+	"nanosoft/repository"
+	generate "nanosoft/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const resendVerificationReply = "If that email address is registered and not yet verified, a verification link has been sent to it"
+
+// VerifyEmail marks the user's address as verified using the token from a
+// verification link. Links are single use: once the address is verified,
+// every link sent to it is refused.
+func (uc *UserController) VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Token string `json:"token" validate:"required"`
+		}
+		if !bindJSON(c, &request) {
+			return
+		}
+
+		claims, msg := generate.ValidateToken(request.Token)
+		if msg != "" || claims.Type != generate.EmailVerification {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or has expired"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID, _ := primitive.ObjectIDFromHex(claims.Uid)
+		user, err := uc.Users.Get(ctx, userID)
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && (user.Email == nil || *user.Email != claims.Email)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or has expired"})
+			return
+		}
+		if err != nil {
+			log.Println("Error loading user for verification:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+		if user.IsVerified() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link has already been used"})
+			return
+		}
+
+		// Conditional, so a link used twice at once only verifies once.
+		now := time.Now()
+		err = uc.Users.UpdateIf(ctx, userID, user.Version, bson.M{"email_verified": true, "verified_at": now, "updated_at": now})
+		if errors.Is(err, repository.ErrConflict) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link has already been used"})
+			return
+		}
+		if err != nil {
+			log.Println("Error marking email verified:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email address verified"})
+	}
+}
+
+// ResendVerification mails a new verification link to an unverified
+// account. Like ForgotPassword it answers the same whatever the address.
+func (uc *UserController) ResendVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if !bindJSON(c, &request) {
+			return
+		}
+
+		uc.Workers.Go(func(context.Context) {
+			var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+			defer cancel()
+			user, err := uc.Users.FindByEmail(ctx, request.Email)
+			if errors.Is(err, repository.ErrNotFound) {
+				return
+			}
+			if err != nil {
+				log.Println("Error finding user for verification:", err)
+				return
+			}
+			if user.IsVerified() {
+				return
+			}
+			if err := uc.sendVerification(ctx, user); err != nil {
+				log.Println("Error queueing verification email:", err)
+			}
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": resendVerificationReply})
+	}
+}
+
+// sendVerification queues an email with a new verification link for user.
+func (uc *UserController) sendVerification(ctx context.Context, user *models.User) error {
+	verifyToken, err := generate.VerificationTokenGenerator(*user.Email, user.User_ID)
+	if err != nil {
+		return err
+	}
+	link, err := url.Parse(config.String("EMAIL_VERIFICATION_URL", "http://localhost:3000/verify-email"))
+	if err != nil {
+		return fmt.Errorf("invalid EMAIL_VERIFICATION_URL: %w", err)
+	}
+	q := link.Query()
+	q.Set("token", verifyToken)
+	link.RawQuery = q.Encode()
+
+	name := ""
+	if user.Name != nil {
+		name = *user.Name
+	}
+	verifyEmail, err := uc.Templates.Compose(ctx, mail.TemplateEmailVerification, "", mail.LinkData{
+		Name:      name,
+		Link:      link.String(),
+		ExpiresIn: generate.EmailVerificationTTL().String(),
+	})
+	if err != nil {
+		return err
+	}
+	verifyEmail.To = *user.Email
+	verifyEmail.Sensitive = true
+	return uc.Outbox.Enqueue(ctx, verifyEmail)
+}
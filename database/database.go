@@ -2,64 +2,161 @@ package database
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
+	"nanosoft/config"
+
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func DBSet() *mongo.Client {
-	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017/"))
-	if err != nil {
-		log.Fatal(err)
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	err = client.Connect(ctx)
-	if err != nil {
-		log.Fatal(err)
-	}
+// Config describes how to reach MongoDB. LoadConfig fills it from MONGO_*
+// environment variables.
+type Config struct {
+	URI      string
+	Database string
 
-	err = client.Ping(context.TODO(), nil)
-	if err != nil {
-		log.Println("failed to connect to mongodb")
-		return nil
-	}
-	fmt.Println("Successfully Connected to the mongodb")
-	return client
-}
+	MaxPoolSize            uint64
+	MinPoolSize            uint64
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
 
-var Client *mongo.Client = DBSet()
+	TLS                   bool
+	TLSCAFile             string
+	TLSInsecureSkipVerify bool
 
-func UserData(client *mongo.Client, CollectionName string) *mongo.Collection {
-	var collection *mongo.Collection = client.Database("Nanosoft").Collection(CollectionName)
-	return collection
+	Username      string
+	Password      string
+	AuthSource    string
+	AuthMechanism string
 
+	// ConnectAttempts is how many times Connect tries before giving up,
+	// waiting RetryBackoff after the first failure and doubling each time.
+	ConnectAttempts int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
 }
 
-func ServiceData(client *mongo.Client, CollectionName string) *mongo.Collection {
-	var productcollection *mongo.Collection = client.Database("Nanosoft").Collection(CollectionName)
-	return productcollection
+func LoadConfig() Config {
+	return Config{
+		URI:                    config.String("MONGO_URI", "mongodb://localhost:27017/"),
+		Database:               config.String("MONGO_DATABASE", "Nanosoft"),
+		MaxPoolSize:            uint64(config.Int("MONGO_MAX_POOL_SIZE", 100)),
+		MinPoolSize:            uint64(config.Int("MONGO_MIN_POOL_SIZE", 0)),
+		ConnectTimeout:         config.Duration("MONGO_CONNECT_TIMEOUT", 10*time.Second),
+		ServerSelectionTimeout: config.Duration("MONGO_SERVER_SELECTION_TIMEOUT", 10*time.Second),
+		TLS:                    config.Bool("MONGO_TLS", false),
+		TLSCAFile:              config.String("MONGO_TLS_CA_FILE", ""),
+		TLSInsecureSkipVerify:  config.Bool("MONGO_TLS_INSECURE_SKIP_VERIFY", false),
+		Username:               config.String("MONGO_USERNAME", ""),
+		Password:               config.String("MONGO_PASSWORD", ""),
+		AuthSource:             config.String("MONGO_AUTH_SOURCE", ""),
+		AuthMechanism:          config.String("MONGO_AUTH_MECHANISM", ""),
+		ConnectAttempts:        config.Int("MONGO_CONNECT_ATTEMPTS", 5),
+		RetryBackoff:           config.Duration("MONGO_RETRY_BACKOFF", time.Second),
+		MaxRetryBackoff:        config.Duration("MONGO_MAX_RETRY_BACKOFF", 30*time.Second),
+	}
 }
 
-func ProjectData(client *mongo.Client, CollectionName string) *mongo.Collection {
-	var productcollection *mongo.Collection = client.Database("Nanosoft").Collection(CollectionName)
-	return productcollection
-}
+// ClientOptions turns cfg into driver options. Settings given here take
+// precedence over the same settings in the URI.
+func (cfg Config) ClientOptions() (*options.ClientOptions, error) {
+	opts := options.Client().
+		ApplyURI(cfg.URI).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetServerSelectionTimeout(cfg.ServerSelectionTimeout)
+	if cfg.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+	if cfg.MinPoolSize > 0 {
+		opts.SetMinPoolSize(cfg.MinPoolSize)
+	}
+
+	if cfg.TLS {
+		tlsConfig := &tls.Config{InsecureSkipVerify: cfg.TLSInsecureSkipVerify}
+		if cfg.TLSCAFile != "" {
+			pem, err := os.ReadFile(cfg.TLSCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading MongoDB CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
 
-func RemarkData(client *mongo.Client, CollectionName string) *mongo.Collection {
-	var productcollection *mongo.Collection = client.Database("Nanosoft").Collection(CollectionName)
-	return productcollection
+	if cfg.Username != "" {
+		opts.SetAuth(options.Credential{
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			AuthSource:    cfg.AuthSource,
+			AuthMechanism: cfg.AuthMechanism,
+		})
+	}
+
+	return opts, opts.Validate()
 }
 
-func EmailData(client *mongo.Client, CollectionName string) *mongo.Collection {
-	var productcollection *mongo.Collection = client.Database("Nanosoft").Collection(CollectionName)
-	return productcollection
+// Connect opens a client and pings the server, retrying with exponential
+// backoff until it succeeds, the attempts run out or ctx is done.
+func Connect(ctx context.Context, cfg Config) (*mongo.Client, error) {
+	opts, err := cfg.ClientOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := cfg.ConnectAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := cfg.RetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		client, err := connectOnce(ctx, opts, cfg.ServerSelectionTimeout)
+		if err == nil {
+			log.Println("Successfully Connected to the mongodb")
+			return client, nil
+		}
+		if attempt >= attempts {
+			return nil, fmt.Errorf("connecting to mongodb after %d attempts: %w", attempt, err)
+		}
+
+		log.Printf("failed to connect to mongodb (attempt %d/%d): %v; retrying in %s", attempt, attempts, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if cfg.MaxRetryBackoff > 0 && backoff > cfg.MaxRetryBackoff {
+			backoff = cfg.MaxRetryBackoff
+		}
+	}
 }
 
-func BlogData(client *mongo.Client, CollectionName string) *mongo.Collection {
-	var productcollection *mongo.Collection = client.Database("Nanosoft").Collection(CollectionName)
-	return productcollection
+func connectOnce(ctx context.Context, opts *options.ClientOptions, timeout time.Duration) (*mongo.Client, error) {
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	pingCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		pingCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := client.Ping(pingCtx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client, nil
 }
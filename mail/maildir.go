@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Maildir writes every message as a file into a maildir, for local
+// development without a mail server. Any mail client that reads maildirs
+// can open it.
+type Maildir struct {
+	dir string
+}
+
+func NewMaildir(dir string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &Maildir{dir: dir}, nil
+}
+
+// Send writes msg to tmp and then moves it into new, so readers never see
+// a partly written message.
+func (m *Maildir) Send(ctx context.Context, msg *Message) error {
+	var raw bytes.Buffer
+	if _, err := msg.WriteTo(&raw); err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.%s.nanosoft.eml", time.Now().UnixNano(), hex.EncodeToString(b))
+	tmp := filepath.Join(m.dir, "tmp", name)
+	if err := os.WriteFile(tmp, raw.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.dir, "new", name))
+}
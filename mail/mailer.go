@@ -0,0 +1,168 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"nanosoft/config"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Message is an email ready to be handed to a Mailer. When both HTML and
+// Text are set the message is sent as multipart/alternative. Headers can
+// add or override headers such as Message-ID or In-Reply-To.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	HTML    string
+	Text    string
+	Headers map[string]string
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Transports selectable with MAIL_TRANSPORT.
+const (
+	TransportSMTP   = "smtp"
+	TransportFile   = "file"
+	TransportMemory = "memory"
+)
+
+// Config selects and configures the transport. The SMTP settings keep the
+// SMIP_* names the API has always used.
+type Config struct {
+	Transport string
+	From      string
+	SMTP      SMTPConfig
+	// Dir is the maildir the file transport writes to.
+	Dir string
+}
+
+func LoadConfig() Config {
+	port := config.Int("SMIP_PORT", 587)
+	tlsMode := TLSStartTLS
+	if port == 465 {
+		tlsMode = TLSImplicit
+	}
+	username := config.String("SMIP_MAIL", "")
+	return Config{
+		Transport: config.String("MAIL_TRANSPORT", TransportSMTP),
+		From:      fromAddress(),
+		SMTP: SMTPConfig{
+			Host:                  config.String("SMIP_HOST", "localhost"),
+			Port:                  port,
+			Username:              username,
+			Password:              config.String("SMIP_PASSWORD", ""),
+			TLS:                   config.String("MAIL_SMTP_TLS", tlsMode),
+			TLSInsecureSkipVerify: config.Bool("MAIL_SMTP_TLS_INSECURE_SKIP_VERIFY", false),
+			Timeout:               config.Duration("MAIL_SMTP_TIMEOUT", 30*time.Second),
+		},
+		Dir: config.String("MAIL_FILE_DIR", "maildir"),
+	}
+}
+
+// NewMailer builds the transport cfg selects. Every message it sends
+// without a From header is sent from cfg.From. Recorded finds the Recorder
+// of the memory transport.
+func NewMailer(cfg Config) (Mailer, error) {
+	var mailer Mailer
+	switch cfg.Transport {
+	case TransportSMTP:
+		smtpMailer, err := NewSMTPMailer(cfg.SMTP)
+		if err != nil {
+			return nil, err
+		}
+		mailer = smtpMailer
+	case TransportFile:
+		dirMailer, err := NewMaildir(cfg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		mailer = dirMailer
+	case TransportMemory:
+		mailer = NewRecorder()
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", cfg.Transport)
+	}
+	return &defaultFrom{Mailer: mailer, from: cfg.From}, nil
+}
+
+type defaultFrom struct {
+	Mailer
+	from string
+}
+
+func (d *defaultFrom) Send(ctx context.Context, msg *Message) error {
+	if msg.From == "" {
+		withFrom := *msg
+		withFrom.From = d.from
+		msg = &withFrom
+	}
+	return d.Mailer.Send(ctx, msg)
+}
+
+// Unwrap returns the transport that messages are sent with.
+func (d *defaultFrom) Unwrap() Mailer {
+	return d.Mailer
+}
+
+// WriteTo writes msg in RFC 5322 format, adding Date and Message-ID headers
+// unless msg.Headers sets them.
+func (msg *Message) WriteTo(w io.Writer) (int64, error) {
+	m := gomail.NewMessage()
+	m.SetHeader("From", msg.From)
+	m.SetHeader("To", msg.To...)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetDateHeader("Date", time.Now())
+	m.SetHeader("Message-ID", NewMessageID(msg.From))
+	for name, value := range msg.Headers {
+		m.SetHeader(name, value)
+	}
+
+	switch {
+	case msg.Text != "" && msg.HTML != "":
+		m.SetBody("text/plain", msg.Text)
+		m.AddAlternative("text/html", msg.HTML)
+	case msg.HTML != "":
+		m.SetBody("text/html", msg.HTML)
+	default:
+		m.SetBody("text/plain", msg.Text)
+	}
+	return m.WriteTo(w)
+}
+
+// fromAddress is the address mail is sent from, MAIL_FROM or else the SMTP
+// username.
+func fromAddress() string {
+	return config.String("MAIL_FROM", config.String("SMIP_MAIL", ""))
+}
+
+// NewMessageID returns a unique Message-ID, including the angle brackets,
+// in the domain of the from address.
+func NewMessageID(from string) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return MessageIDFor(fmt.Sprintf("%d.%s", time.Now().UnixNano(), hex.EncodeToString(b)), from)
+}
+
+// MessageIDFor returns the Message-ID with the local part key in the domain
+// of the from address. The same key always gives the same ID.
+func MessageIDFor(key, from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = strings.TrimSuffix(from[at+1:], ">")
+	}
+	return "<" + key + "@" + domain + ">"
+}
@@ -0,0 +1,64 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestNewMailerMemory(t *testing.T) {
+	mailer, err := NewMailer(Config{Transport: TransportMemory, From: "site@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder, ok := Recorded(mailer)
+	if !ok {
+		t.Fatal("Recorded found no Recorder behind the memory transport")
+	}
+
+	ctx := context.Background()
+	mailer.Send(ctx, &Message{To: []string{"a@example.com"}, Subject: "One"})
+	mailer.Send(ctx, &Message{From: "other@example.com", To: []string{"b@example.com"}, Subject: "Two"})
+
+	messages := recorder.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("recorded %d messages, want 2", len(messages))
+	}
+	if messages[0].From != "site@example.com" || messages[0].Subject != "One" {
+		t.Errorf("first message = %+v, want the default From", messages[0])
+	}
+	if messages[1].From != "other@example.com" {
+		t.Errorf("second message From = %q, want other@example.com", messages[1].From)
+	}
+}
+
+func TestRecordedOtherTransport(t *testing.T) {
+	mailer, err := NewMailer(Config{Transport: TransportFile, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Recorded(mailer); ok {
+		t.Error("Recorded found a Recorder behind the file transport")
+	}
+}
+
+func TestRecorderLimit(t *testing.T) {
+	recorder := &Recorder{Limit: 3}
+	for i := 1; i <= 5; i++ {
+		recorder.Send(context.Background(), &Message{Subject: fmt.Sprint(i)})
+	}
+	messages := recorder.Messages()
+	if len(messages) != 3 {
+		t.Fatalf("kept %d messages, want 3", len(messages))
+	}
+	for i, want := range []string{"3", "4", "5"} {
+		if messages[i].Subject != want {
+			t.Errorf("message %d = %q, want %q", i, messages[i].Subject, want)
+		}
+	}
+
+	recorder.Reset()
+	if n := len(recorder.Messages()); n != 0 {
+		t.Errorf("kept %d messages after Reset, want 0", n)
+	}
+}
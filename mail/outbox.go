@@ -0,0 +1,196 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"nanosoft/background"
+	"nanosoft/config"
+	"nanosoft/models"
+	"nanosoft/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OutboxConfig tunes delivery. A failed attempt is retried after
+// RetryBackoff, doubling each time up to MaxRetryBackoff, until the email
+// has been tried MaxAttempts times and is marked failed. From is only used
+// for the domain of Message-IDs.
+type OutboxConfig struct {
+	From            string
+	Workers         int
+	PollInterval    time.Duration
+	SendTimeout     time.Duration
+	MaxAttempts     int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+func LoadOutboxConfig() OutboxConfig {
+	return OutboxConfig{
+		From:            fromAddress(),
+		Workers:         config.Int("MAIL_WORKERS", 2),
+		PollInterval:    config.Duration("MAIL_POLL_INTERVAL", 10*time.Second),
+		SendTimeout:     config.Duration("MAIL_SEND_TIMEOUT", time.Minute),
+		MaxAttempts:     config.Int("MAIL_MAX_ATTEMPTS", 6),
+		RetryBackoff:    config.Duration("MAIL_RETRY_BACKOFF", 30*time.Second),
+		MaxRetryBackoff: config.Duration("MAIL_MAX_RETRY_BACKOFF", time.Hour),
+	}
+}
+
+// Outbox persists outgoing email and delivers it from a pool of workers.
+// Because the queue lives in the repository, emails survive restarts and
+// any replica's workers can deliver them.
+type Outbox struct {
+	Repo   repository.OutboxRepository
+	Mailer Mailer
+	Config OutboxConfig
+
+	wake chan struct{}
+}
+
+func NewOutbox(repo repository.OutboxRepository, mailer Mailer, cfg OutboxConfig) *Outbox {
+	return &Outbox{Repo: repo, Mailer: mailer, Config: cfg, wake: make(chan struct{}, 1)}
+}
+
+// Enqueue stores email as pending and wakes a worker to send it. An email
+// without a Mail_ID is given a new one.
+func (o *Outbox) Enqueue(ctx context.Context, email *models.OutboundEmail) error {
+	now := time.Now()
+	email.Outbound_ID = primitive.NewObjectID()
+	if email.Mail_ID == "" {
+		email.Mail_ID = NewMessageID(o.Config.From)
+	}
+	email.Status = models.OutboxPending
+	email.Attempts = 0
+	email.Max_Attempts = o.Config.MaxAttempts
+	email.Next_Attempt_At = now
+	email.Created_At = now
+	email.Updated_At = now
+	if err := o.Repo.Create(ctx, email); err != nil {
+		return err
+	}
+	o.notify()
+	return nil
+}
+
+// Retry makes a failed email due again.
+func (o *Outbox) Retry(ctx context.Context, id primitive.ObjectID) error {
+	if err := o.Repo.Requeue(ctx, id, time.Now()); err != nil {
+		return err
+	}
+	o.notify()
+	return nil
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the workers on group until it is stopped.
+func (o *Outbox) Start(group *background.Group) {
+	workers := o.Config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		group.Go(o.work)
+	}
+}
+
+func (o *Outbox) work(ctx context.Context) {
+	ticker := time.NewTicker(o.Config.PollInterval)
+	defer ticker.Stop()
+	for {
+		o.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain delivers due emails until there are none left or ctx is done.
+func (o *Outbox) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		email, err := o.Repo.Claim(ctx, time.Now(), o.Config.SendTimeout)
+		if errors.Is(err, repository.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			log.Println("Error claiming outbound email:", err)
+			return
+		}
+		o.deliver(email)
+	}
+}
+
+// deliver makes one attempt and records its outcome. It deliberately does
+// not use the worker's context, so shutdown lets the attempt finish.
+func (o *Outbox) deliver(email *models.OutboundEmail) {
+	ctx, cancel := context.WithTimeout(context.Background(), o.Config.SendTimeout)
+	defer cancel()
+
+	headers := map[string]string{}
+	if email.Mail_ID != "" {
+		headers["Message-ID"] = email.Mail_ID
+	}
+	if email.In_Reply_To != "" {
+		headers["In-Reply-To"] = email.In_Reply_To
+	}
+	if len(email.References) > 0 {
+		headers["References"] = strings.Join(email.References, " ")
+	}
+	sendErr := o.Mailer.Send(ctx, &Message{
+		To:      []string{email.To},
+		Subject: email.Subject,
+		HTML:    email.Body,
+		Text:    email.Text,
+		Headers: headers,
+	})
+	now := time.Now()
+	attempts := email.Attempts + 1
+	set := bson.M{"attempts": attempts, "locked_until": nil, "updated_at": now}
+	switch {
+	case sendErr == nil:
+		set["status"] = models.OutboxSent
+		set["sent_at"] = now
+		set["last_error"] = ""
+		if email.Sensitive {
+			set["body"] = ""
+			set["text"] = ""
+		}
+	case attempts >= email.Max_Attempts:
+		log.Printf("Giving up on email %s to %s after %d attempts: %v", email.Outbound_ID.Hex(), email.To, attempts, sendErr)
+		set["status"] = models.OutboxFailed
+		set["last_error"] = sendErr.Error()
+	default:
+		set["status"] = models.OutboxPending
+		set["last_error"] = sendErr.Error()
+		set["next_attempt_at"] = now.Add(o.backoff(attempts))
+	}
+
+	if err := o.Repo.Update(ctx, email.Outbound_ID, set); err != nil {
+		log.Println("Error recording email delivery:", err)
+	}
+}
+
+func (o *Outbox) backoff(attempts int) time.Duration {
+	backoff := o.Config.RetryBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if o.Config.MaxRetryBackoff > 0 && backoff >= o.Config.MaxRetryBackoff {
+			return o.Config.MaxRetryBackoff
+		}
+	}
+	return backoff
+}
@@ -0,0 +1,146 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"nanosoft/models"
+	"nanosoft/repository"
+)
+
+// flaky fails as many sends as failures, then records the rest.
+type flaky struct {
+	*Recorder
+	failures int
+}
+
+func (f *flaky) Send(ctx context.Context, msg *Message) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("connection refused")
+	}
+	return f.Recorder.Send(ctx, msg)
+}
+
+func newTestOutbox(mailer Mailer) *Outbox {
+	return NewOutbox(repository.NewMemoryStore().Outbox, mailer, OutboxConfig{
+		From:            "site@example.com",
+		Workers:         1,
+		PollInterval:    time.Hour,
+		SendTimeout:     time.Minute,
+		MaxAttempts:     3,
+		RetryBackoff:    -time.Second,
+		MaxRetryBackoff: time.Hour,
+	})
+}
+
+func outboundEmail(t *testing.T, o *Outbox, email *models.OutboundEmail) *models.OutboundEmail {
+	t.Helper()
+	stored, err := o.Repo.Get(context.Background(), email.Outbound_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestOutboxDelivers(t *testing.T) {
+	recorder := NewRecorder()
+	o := newTestOutbox(recorder)
+	ctx := context.Background()
+
+	email := &models.OutboundEmail{
+		To:          "ann@example.com",
+		Subject:     "Re: Hello",
+		Body:        "<p>Hi</p>",
+		Text:        "Hi",
+		Sensitive:   true,
+		In_Reply_To: "<root@example.com>",
+		References:  []string{"<root@example.com>"},
+	}
+	if err := o.Enqueue(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+	o.drain(ctx)
+
+	messages := recorder.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if len(msg.To) != 1 || msg.To[0] != "ann@example.com" || msg.Subject != "Re: Hello" || msg.Text != "Hi" || msg.HTML != "<p>Hi</p>" {
+		t.Errorf("sent %+v", msg)
+	}
+	if msg.Headers["Message-ID"] != email.Mail_ID || msg.Headers["In-Reply-To"] != "<root@example.com>" || msg.Headers["References"] != "<root@example.com>" {
+		t.Errorf("headers = %v, want Message-ID %s and the thread", msg.Headers, email.Mail_ID)
+	}
+
+	stored := outboundEmail(t, o, email)
+	if stored.Status != models.OutboxSent || stored.Attempts != 1 || stored.Sent_At == nil {
+		t.Errorf("stored email = %+v, want sent after one attempt", stored)
+	}
+	if stored.Body != "" || stored.Text != "" {
+		t.Error("the body of a sensitive email was kept after sending it")
+	}
+}
+
+func TestOutboxRetries(t *testing.T) {
+	mailer := &flaky{Recorder: NewRecorder(), failures: 1}
+	o := newTestOutbox(mailer)
+	ctx := context.Background()
+
+	email := &models.OutboundEmail{To: "ann@example.com", Subject: "Hello"}
+	if err := o.Enqueue(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+	// The negative backoff makes the retry due at once, so one drain
+	// covers both attempts.
+	o.drain(ctx)
+
+	if n := len(mailer.Messages()); n != 1 {
+		t.Fatalf("sent %d messages, want 1", n)
+	}
+	stored := outboundEmail(t, o, email)
+	if stored.Status != models.OutboxSent || stored.Attempts != 2 || stored.Last_Error != "" {
+		t.Errorf("stored email = %+v, want sent on the second attempt", stored)
+	}
+}
+
+func TestOutboxGivesUp(t *testing.T) {
+	mailer := &flaky{Recorder: NewRecorder(), failures: 10}
+	o := newTestOutbox(mailer)
+	ctx := context.Background()
+
+	email := &models.OutboundEmail{To: "ann@example.com", Subject: "Hello"}
+	if err := o.Enqueue(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+	o.drain(ctx)
+
+	stored := outboundEmail(t, o, email)
+	if stored.Status != models.OutboxFailed || stored.Attempts != 3 || stored.Last_Error != "connection refused" {
+		t.Fatalf("stored email = %+v, want failed after three attempts", stored)
+	}
+
+	mailer.failures = 0
+	if err := o.Retry(ctx, email.Outbound_ID); err != nil {
+		t.Fatal(err)
+	}
+	o.drain(ctx)
+	if n := len(mailer.Messages()); n != 1 {
+		t.Errorf("sent %d messages after the retry, want 1", n)
+	}
+	if stored := outboundEmail(t, o, email); stored.Status != models.OutboxSent {
+		t.Errorf("status after the retry = %s, want sent", stored.Status)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	o := &Outbox{Config: OutboxConfig{RetryBackoff: time.Second, MaxRetryBackoff: 5 * time.Second}}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := o.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
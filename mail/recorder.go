@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"context"
+	"sync"
+)
+
+// RecorderLimit is how many messages a Recorder from NewRecorder keeps.
+const RecorderLimit = 1000
+
+// Recorder keeps sent messages in memory instead of delivering them, so
+// tests can assert on what would have been sent. Once it holds Limit
+// messages the oldest are dropped; a Limit of zero keeps them all.
+type Recorder struct {
+	Limit int
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{Limit: RecorderLimit}
+}
+
+func (r *Recorder) Send(ctx context.Context, msg *Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, *msg)
+	if r.Limit > 0 && len(r.messages) > r.Limit {
+		r.messages = append([]Message(nil), r.messages[len(r.messages)-r.Limit:]...)
+	}
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Message(nil), r.messages...)
+}
+
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = nil
+}
+
+// Recorded returns the Recorder behind m, as built by NewMailer when
+// MAIL_TRANSPORT is memory, and reports whether there is one.
+func Recorded(m Mailer) (*Recorder, bool) {
+	for {
+		switch mailer := m.(type) {
+		case *Recorder:
+			return mailer, true
+		case interface{ Unwrap() Mailer }:
+			m = mailer.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// TLS modes for SMTPConfig.TLS.
+const (
+	// TLSStartTLS connects in plain text and requires the server to
+	// upgrade the connection with STARTTLS before anything is sent.
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects over TLS from the start, usually on port 465.
+	TLSImplicit = "tls"
+	// TLSNone never encrypts. Only meant for a local development server;
+	// net/smtp refuses to send credentials over it to any other host.
+	TLSNone = "none"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      string
+	// TLSInsecureSkipVerify disables certificate verification, for test
+	// servers with self-signed certificates.
+	TLSInsecureSkipVerify bool
+	Timeout               time.Duration
+}
+
+// SMTPMailer sends through an SMTP server, opening one connection per
+// message.
+type SMTPMailer struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	switch cfg.TLS {
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q", cfg.TLS)
+	}
+	if cfg.Host == "" || cfg.Port == 0 {
+		return nil, errors.New("SMTP host and port are required")
+	}
+	return &SMTPMailer{cfg: cfg}, nil
+}
+
+func (m *SMTPMailer) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName:         m.cfg.Host,
+		InsecureSkipVerify: m.cfg.TLSInsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	var raw bytes.Buffer
+	if _, err := msg.WriteTo(&raw); err != nil {
+		return err
+	}
+
+	if m.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.cfg.Timeout)
+		defer cancel()
+	}
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if m.cfg.TLS == TLSImplicit {
+		conn = tls.Client(conn, m.tlsConfig())
+	}
+
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.cfg.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+		if err := client.StartTLS(m.tlsConfig()); err != nil {
+			return err
+		}
+	}
+	if m.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(addressOf(msg.From)); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(addressOf(to)); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// addressOf strips the display name from an address such as
+// "Nanosoft <info@nanosoft.com>".
+func addressOf(address string) string {
+	if parsed, err := netmail.ParseAddress(address); err == nil {
+		return parsed.Address
+	}
+	return address
+}
@@ -0,0 +1,357 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"nanosoft/models"
+	"nanosoft/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//go:embed templates
+var builtin embed.FS
+
+// Names of the email templates.
+const (
+	TemplateContactNotification    = "contact_notification"
+	TemplateContactAcknowledgement = "contact_acknowledgement"
+	TemplateContactReply           = "contact_reply"
+	TemplatePasswordReset          = "password_reset"
+	TemplateEmailVerification      = "email_verification"
+)
+
+// ContactData is rendered by the contact form templates.
+type ContactData struct {
+	Name        string
+	Email       string
+	Phone       string
+	CompanyName string
+	Message     string
+	Reference   string
+	Received    time.Time
+}
+
+// ReplyData is rendered by the template of an admin's reply to a contact
+// message. An empty Subject lets the template choose one.
+type ReplyData struct {
+	Name      string
+	Subject   string
+	Body      string
+	Author    string
+	Reference string
+}
+
+// LinkData is rendered by the templates that send the user a link.
+type LinkData struct {
+	Name      string
+	Link      string
+	ExpiresIn string
+}
+
+// samples holds example data for every template. It defines which names
+// exist and is what edited templates are test-rendered with before they
+// are saved, so a template referring to a missing field is rejected.
+var samples = map[string]interface{}{
+	TemplateContactNotification: ContactData{
+		Name: "Jane Doe", Email: "jane@example.com", Phone: "+1 555 0100", CompanyName: "Example Ltd",
+		Message: "Hello,\nWe would like a quote.", Reference: "NS-6AD3B07D-327D3F", Received: time.Now(),
+	},
+	TemplateContactAcknowledgement: ContactData{
+		Name: "Jane Doe", Email: "jane@example.com", Message: "Hello,\nWe would like a quote.",
+		Reference: "NS-6AD3B07D-327D3F", Received: time.Now(),
+	},
+	TemplateContactReply: ReplyData{
+		Name: "Jane Doe", Body: "Thank you for your interest.\nOur quote is attached.", Author: "John Smith",
+		Reference: "NS-6AD3B07D-327D3F",
+	},
+	TemplatePasswordReset:     LinkData{Name: "Jane Doe", Link: "https://example.com/reset-password?token=sample", ExpiresIn: "1h0m0s"},
+	TemplateEmailVerification: LinkData{Name: "Jane Doe", Link: "https://example.com/verify-email?token=sample", ExpiresIn: "48h0m0s"},
+}
+
+// ErrUnknownTemplate is returned for a name that is not in TemplateNames.
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// TemplateError reports a template part that does not parse or render.
+type TemplateError struct {
+	Part string
+	Err  error
+}
+
+func (e *TemplateError) Error() string {
+	return "invalid " + e.Part + " template: " + e.Err.Error()
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// TemplateNames lists every template, sorted.
+func TemplateNames() []string {
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Where a template's source came from.
+const (
+	OriginDatabase  = "database"
+	OriginDirectory = "directory"
+	OriginBuiltin   = "builtin"
+)
+
+// Source is the unparsed text of a template. Locale is empty for the
+// template used when there is none for the recipient's language.
+type Source struct {
+	Name    string `json:"name"`
+	Locale  string `json:"locale,omitempty"`
+	Origin  string `json:"origin"`
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// Rendered is a template executed with data, ready to send.
+type Rendered struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// Templates finds each template in the repository, where admins' edits are
+// kept, then as <name>.subject.txt, <name>.html and <name>.txt files in
+// Dir, and finally among the built-in defaults. A translation for a locale
+// such as "fr" is looked up the same way, as <name>.fr.html and so on in
+// Dir, before falling back to the untranslated template.
+type Templates struct {
+	Repo repository.TemplateRepository
+	Dir  string
+}
+
+func NewTemplates(repo repository.TemplateRepository, dir string) *Templates {
+	return &Templates{Repo: repo, Dir: dir}
+}
+
+// Source returns the template that is in effect for name in locale. For
+// "pt-br" it tries "pt-br", then "pt", then the untranslated template.
+func (t *Templates) Source(ctx context.Context, name, locale string) (*Source, error) {
+	if _, ok := samples[name]; !ok {
+		return nil, ErrUnknownTemplate
+	}
+	for _, candidate := range localeFallbacks(locale) {
+		saved, err := t.Repo.FindByName(ctx, name, candidate)
+		if err == nil {
+			return &Source{
+				Name: name, Locale: candidate, Origin: OriginDatabase,
+				Subject: saved.Subject, HTML: saved.HTML, Text: saved.Text,
+			}, nil
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			return nil, err
+		}
+		if candidate == "" {
+			return t.Default(name)
+		}
+		src, err := t.translation(name, candidate)
+		if err != nil || src != nil {
+			return src, err
+		}
+	}
+	return t.Default(name)
+}
+
+// translation reads a translated template from Dir. Unlike the default
+// ones, all three parts must be there, so languages are never mixed.
+func (t *Templates) translation(name, locale string) (*Source, error) {
+	if t.Dir == "" {
+		return nil, nil
+	}
+	base := filepath.Join(t.Dir, name+"."+locale)
+	if _, err := os.Stat(base + ".html"); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	src := &Source{Name: name, Locale: locale, Origin: OriginDirectory}
+	parts := map[string]*string{".subject.txt": &src.Subject, ".html": &src.HTML, ".txt": &src.Text}
+	for suffix, part := range parts {
+		content, err := os.ReadFile(base + suffix)
+		if err != nil {
+			return nil, err
+		}
+		*part = string(content)
+	}
+	return src, nil
+}
+
+// NormalizeLocale lower-cases a language tag such as "pt_BR" to "pt-br".
+// It reports false for anything that is not shaped like a language tag.
+func NormalizeLocale(locale string) (string, bool) {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	return locale, localePattern.MatchString(locale)
+}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8}){0,2}$`)
+
+// localeFallbacks lists locale and its more general forms, ending with "".
+func localeFallbacks(locale string) []string {
+	var chain []string
+	for locale != "" {
+		chain = append(chain, locale)
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return append(chain, "")
+}
+
+// Default returns the template for name ignoring admins' edits. Each part
+// found in Dir overrides the built-in one.
+func (t *Templates) Default(name string) (*Source, error) {
+	if _, ok := samples[name]; !ok {
+		return nil, ErrUnknownTemplate
+	}
+	src := &Source{Name: name, Origin: OriginBuiltin}
+	parts := map[string]*string{".subject.txt": &src.Subject, ".html": &src.HTML, ".txt": &src.Text}
+	for suffix, part := range parts {
+		if t.Dir != "" {
+			content, err := os.ReadFile(filepath.Join(t.Dir, name+suffix))
+			if err == nil {
+				*part = string(content)
+				src.Origin = OriginDirectory
+				continue
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+		content, err := builtin.ReadFile("templates/" + name + suffix)
+		if err != nil {
+			return nil, err
+		}
+		*part = string(content)
+	}
+	return src, nil
+}
+
+// Render executes the template in effect for name in locale with data.
+func (t *Templates) Render(ctx context.Context, name, locale string, data interface{}) (*Rendered, error) {
+	src, err := t.Source(ctx, name, locale)
+	if err != nil {
+		return nil, err
+	}
+	return src.Render(data)
+}
+
+// Compose renders name into an outbound email of that kind. The caller
+// fills in the recipient before queueing it.
+func (t *Templates) Compose(ctx context.Context, name, locale string, data interface{}) (*models.OutboundEmail, error) {
+	rendered, err := t.Render(ctx, name, locale, data)
+	if err != nil {
+		return nil, err
+	}
+	return &models.OutboundEmail{Kind: name, Subject: rendered.Subject, Body: rendered.HTML, Text: rendered.Text}, nil
+}
+
+// Preview renders src with the sample data for its name.
+func Preview(src *Source) (*Rendered, error) {
+	sample, ok := samples[src.Name]
+	if !ok {
+		return nil, ErrUnknownTemplate
+	}
+	return src.Render(sample)
+}
+
+// Save stores an admin's version of a template after checking it renders.
+func (t *Templates) Save(ctx context.Context, src *Source, by string) error {
+	if _, err := Preview(src); err != nil {
+		return err
+	}
+	now := time.Now()
+	existing, err := t.Repo.FindByName(ctx, src.Name, src.Locale)
+	if errors.Is(err, repository.ErrNotFound) {
+		return t.Repo.Create(ctx, &models.EmailTemplate{
+			Template_ID: primitive.NewObjectID(),
+			Name:        src.Name,
+			Locale:      src.Locale,
+			Subject:     src.Subject,
+			HTML:        src.HTML,
+			Text:        src.Text,
+			Updated_By:  by,
+			Created_At:  now,
+			Updated_At:  now,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return t.Repo.Update(ctx, existing.Template_ID, bson.M{
+		"subject":    src.Subject,
+		"html":       src.HTML,
+		"text":       src.Text,
+		"updated_by": by,
+		"updated_at": now,
+	})
+}
+
+// Reset drops an admin's version of a template, restoring the default.
+func (t *Templates) Reset(ctx context.Context, name, locale string) error {
+	if _, ok := samples[name]; !ok {
+		return ErrUnknownTemplate
+	}
+	return t.Repo.DeleteByName(ctx, name, locale)
+}
+
+// Render parses and executes every part of src. The subject is collapsed
+// onto one line. User input in data is escaped in the HTML part.
+func (src *Source) Render(data interface{}) (*Rendered, error) {
+	subject, err := executeText("subject", src.Subject, data)
+	if err != nil {
+		return nil, err
+	}
+	text, err := executeText("text", src.Text, data)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := htmltemplate.New("html").Parse(src.HTML)
+	if err != nil {
+		return nil, &TemplateError{Part: "html", Err: err}
+	}
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, data); err != nil {
+		return nil, &TemplateError{Part: "html", Err: err}
+	}
+	return &Rendered{
+		Subject: strings.Join(strings.Fields(subject), " "),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text) + "\n",
+	}, nil
+}
+
+func executeText(part, source string, data interface{}) (string, error) {
+	tmpl, err := texttemplate.New(part).Parse(source)
+	if err != nil {
+		return "", &TemplateError{Part: part, Err: err}
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", &TemplateError{Part: part, Err: err}
+	}
+	return out.String(), nil
+}
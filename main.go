@@ -1,19 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
-	"nanosoft/middleware"
+	"nanosoft/background"
+	"nanosoft/config"
+	"nanosoft/database"
+	"nanosoft/mail"
+	"nanosoft/repository"
 	"nanosoft/routes"
+	"nanosoft/storage"
+	"net/http"
 	"os"
-
-	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading .env file")
+	if err := config.Load(); err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
 	}
 
 	port := os.Getenv("PORT")
@@ -21,22 +28,82 @@ func main() {
 		port = "8000"
 	}
 
-	router := gin.New()
-	router.Use(gin.Logger())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dbConfig := database.LoadConfig()
+	client, err := database.Connect(ctx, dbConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	workers := background.NewGroup()
+	store := repository.NewMongoStore(client.Database(dbConfig.Database))
+	if err := store.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if config.String("RATE_LIMIT_STORE", "mongo") == "memory" {
+		store.Buckets = repository.NewMemoryBuckets()
+	}
+	mailer, err := mail.NewMailer(mail.LoadConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+	outbox := mail.NewOutbox(store.Outbox, mailer, mail.LoadOutboxConfig())
+	outbox.Start(workers)
+	files, err := storage.NewLocal(storage.LoadConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if retention := config.Duration("TRASH_RETENTION", 30*24*time.Hour); retention > 0 {
+		store.StartTrashPurge(workers, retention, config.Duration("TRASH_PURGE_INTERVAL", time.Hour), files)
+	}
+	router := routes.NewRouter(store, outbox, workers, files)
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           router,
+		ReadHeaderTimeout: config.Duration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       config.Duration("SERVER_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      config.Duration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       config.Duration("SERVER_IDLE_TIMEOUT", 60*time.Second),
+	}
 
-	publicRoutes := router.Group("/")
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
 
-	authenticatedRoutes := router.Group("/")
-	authenticatedRoutes.Use(middleware.Authentication())
+	var failed bool
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Server error: %v", err)
+			failed = true
+		}
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	}
+	stop()
 
-	adminRoutes := router.Group("/")
-	adminRoutes.Use(middleware.Authentication())
-	adminRoutes.Use(middleware.AuthorizeRole([]int{1, 2}))
+	grace := config.Duration("SHUTDOWN_GRACE_PERIOD", 20*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
 
-	routes.UserRoutes(publicRoutes, authenticatedRoutes, adminRoutes)
-	routes.ServiceRoutes(publicRoutes, authenticatedRoutes, adminRoutes)
-	routes.ProjectRoutes(publicRoutes, authenticatedRoutes, adminRoutes)
-	routes.RemarkRoutes(publicRoutes, authenticatedRoutes, adminRoutes)
-	routes.EmailRoutes(publicRoutes, authenticatedRoutes, adminRoutes)
-	log.Fatal(router.Run(":" + port))
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error draining requests: %v", err)
+	}
+	if err := workers.Stop(shutdownCtx); err != nil {
+		log.Printf("Error waiting for background work: %v", err)
+	}
+	if err := client.Disconnect(shutdownCtx); err != nil {
+		log.Printf("Error disconnecting from mongodb: %v", err)
+	}
+	if failed {
+		// The server never ran or stopped on its own; let the supervisor
+		// know, now that everything has been cleaned up.
+		cancel()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"nanosoft/models"
+	"nanosoft/repository"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	auditResourceKey = "audit_resource"
+	auditIDKey       = "audit_resource_id"
+	auditChangesKey  = "audit_changes"
+)
+
+// unaudited fields are bookkeeping that the entry already holds or that
+// changes with every write, so they are left out of diffs.
+var unaudited = map[string]bool{"_id": true, "created_at": true, "updated_at": true, "version": true}
+
+// redacted fields are secrets; a diff only shows that they changed.
+var redacted = map[string]bool{"password": true, "token": true, "refresh_token": true}
+
+// Audit records every request that changes something in the audit log,
+// once it has been handled, with the authenticated user, the route, the
+// response status and the client. Reads are recorded too when the handler
+// names what was read with AuditResource. It belongs after Authentication.
+func Audit(entries repository.AuditRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		_, named := c.Get(auditResourceKey)
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if !named {
+				return
+			}
+		}
+
+		entry := models.AuditEntry{
+			Audit_ID:    primitive.NewObjectID(),
+			Actor_ID:    c.GetString("uid"),
+			Actor_Email: c.GetString("email"),
+			Action:      c.Request.Method + " " + c.FullPath(),
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			Resource:    c.GetString(auditResourceKey),
+			Resource_ID: c.GetString(auditIDKey),
+			Status:      c.Writer.Status(),
+			IP:          c.ClientIP(),
+			User_Agent:  c.Request.UserAgent(),
+			Created_At:  time.Now(),
+		}
+		if !named {
+			entry.Resource = strings.SplitN(strings.TrimPrefix(c.FullPath(), "/"), "/", 2)[0]
+			entry.Resource_ID = c.Param("id")
+		}
+		if changes, ok := c.Get(auditChangesKey); ok {
+			entry.Changes = changes.([]models.FieldChange)
+		}
+
+		// The entry is written even if the client has gone away.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), 10*time.Second)
+		defer cancel()
+		if err := entries.Append(ctx, &entry); err != nil {
+			log.Printf("Error writing audit entry for %s by %s: %v", entry.Action, entry.Actor_ID, err)
+		}
+	}
+}
+
+// AuditResource names the resource a request acts on, for routes whose
+// first path segment or id parameter do not. On a read it also makes
+// Audit record the request.
+func AuditResource(c *gin.Context, resource, id string) {
+	c.Set(auditResourceKey, resource)
+	c.Set(auditIDKey, id)
+}
+
+// AuditChange records the fields that differ between before and after,
+// compared by their JSON names. Either may be nil for a document that was
+// created or removed.
+func AuditChange(c *gin.Context, before, after interface{}) {
+	changes, err := Diff(before, after)
+	if err != nil {
+		log.Println("Error comparing audited documents:", err)
+		return
+	}
+	c.Set(auditChangesKey, changes)
+}
+
+// Diff lists the top-level fields whose JSON values differ between before
+// and after, by field name. Null and empty strings count as missing.
+func Diff(before, after interface{}) ([]models.FieldChange, error) {
+	old, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for name := range old {
+		names[name] = true
+	}
+	for name := range changed {
+		names[name] = true
+	}
+	changes := []models.FieldChange{}
+	for name := range names {
+		if unaudited[name] || reflect.DeepEqual(old[name], changed[name]) {
+			continue
+		}
+		change := models.FieldChange{Field: name, Before: old[name], After: changed[name]}
+		if redacted[name] {
+			change.Before, change.After = "[redacted]", "[redacted]"
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+func jsonFields(doc interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if doc == nil || reflect.ValueOf(doc).Kind() == reflect.Ptr && reflect.ValueOf(doc).IsNil() {
+		return fields, nil
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		if value == nil || value == "" {
+			delete(fields, name)
+		}
+	}
+	return fields, nil
+}
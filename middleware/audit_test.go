@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"nanosoft/models"
+)
+
+func TestDiff(t *testing.T) {
+	str := func(s string) *string { return &s }
+	before := &models.User{
+		Name:       str("Ann"),
+		Password:   str("$2a$14$old"),
+		Token:      str("old-token"),
+		Role:       0,
+		AvatarPath: str(""),
+		Version:    1,
+		Updated_At: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	after := &models.User{
+		Name:       str("Bob"),
+		Password:   str("$2a$14$new"),
+		Token:      str("new-token"),
+		Role:       1,
+		Version:    2,
+		Updated_At: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.FieldChange{
+		{Field: "name", Before: "Ann", After: "Bob"},
+		{Field: "password", Before: "[redacted]", After: "[redacted]"},
+		{Field: "role", Before: 0.0, After: 1.0},
+		{Field: "token", Before: "[redacted]", After: "[redacted]"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Diff = %+v, want %+v", changes, want)
+	}
+}
+
+func TestDiffCreateAndRemove(t *testing.T) {
+	title := "Hosting"
+	doc := &models.Service{Title: &title, Version: 1}
+
+	created, err := Diff(nil, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []models.FieldChange{{Field: "title", After: "Hosting"}}; !reflect.DeepEqual(created, want) {
+		t.Errorf("Diff(nil, doc) = %+v, want %+v", created, want)
+	}
+
+	var none *models.Service
+	removed, err := Diff(doc, none)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []models.FieldChange{{Field: "title", Before: "Hosting"}}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("Diff(doc, nil) = %+v, want %+v", removed, want)
+	}
+
+	same, err := Diff(doc, doc)
+	if err != nil || len(same) != 0 {
+		t.Errorf("Diff(doc, doc) = %+v, %v, want no changes", same, err)
+	}
+}
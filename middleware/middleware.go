@@ -1,14 +1,19 @@
 package middleware
 
 import (
+	"log"
 	"net/http"
 
+	"nanosoft/repository"
 	token "nanosoft/tokens"
 
 	"github.com/gin-gonic/gin"
 )
 
-func Authentication() gin.HandlerFunc {
+// Authentication accepts a valid access token whose ID and session family
+// have not been revoked. Under the VerifyAccess policy, tokens of users who
+// have not verified their email address are refused too.
+func Authentication(revocations repository.RevocationRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ClientToken := c.Request.Header.Get("token")
 		if ClientToken == "" {
@@ -22,9 +27,34 @@ func Authentication() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if claims.Type != token.AccessToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not an access token"})
+			c.Abort()
+			return
+		}
+		if claims.Unverified && token.VerificationPolicy() == token.VerifyAccess {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address has not been verified"})
+			c.Abort()
+			return
+		}
+		revoked, lookupErr := revocations.IsRevoked(c.Request.Context(), claims.Id, claims.Sid)
+		if lookupErr != nil {
+			log.Println("Error checking token revocation:", lookupErr)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify token"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
 		c.Set("email", claims.Email)
 		c.Set("uid", claims.Uid)
 		c.Set("role", claims.Role)
+		c.Set("sid", claims.Sid)
+		c.Set("jti", claims.Id)
+		c.Set("token_expires_at", claims.ExpiresAt)
 		c.Next()
 	}
 }
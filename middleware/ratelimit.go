@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nanosoft/config"
+	"nanosoft/repository"
+	token "nanosoft/tokens"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limit allows bursts of up to Requests requests, refilled evenly over Per.
+// Name separates the buckets of different limits that share a key.
+type Limit struct {
+	Name     string
+	Requests int
+	Per      time.Duration
+}
+
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// LoadLimit reads a limit such as "5/1h" (five requests an hour) from the
+// environment variable key, falling back to def. "off" disables the limit.
+func LoadLimit(key string, def Limit) Limit {
+	v := config.String(key, "")
+	if v == "" {
+		return def
+	}
+	if v == "off" {
+		def.Requests = 0
+		return def
+	}
+	requests, per, ok := strings.Cut(v, "/")
+	n, err := strconv.Atoi(requests)
+	d, durErr := time.ParseDuration(per)
+	if !ok || err != nil || durErr != nil || n < 0 || d <= 0 {
+		log.Printf("config: %s=%q is not a limit like 5/1h, using %d/%s", key, v, def.Requests, def.Per)
+		return def
+	}
+	def.Requests, def.Per = n, d
+	return def
+}
+
+// KeyFunc picks the bucket a request is counted against.
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests per client address.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser counts requests per authenticated user, and per address for
+// requests that have not been through Authentication.
+func ByUser(c *gin.Context) string {
+	if uid := c.GetString("uid"); uid != "" {
+		return "user:" + uid
+	}
+	return ByIP(c)
+}
+
+// ByAPIKey counts requests per X-API-Key header, and per address for
+// requests without one. Keys are hashed so they are never stored.
+func ByAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return "apikey:" + token.HashToken(key)
+	}
+	return ByIP(c)
+}
+
+// Allow takes one token from the bucket for key under limit. It is for
+// limits on things other than requests, such as the emails sent to one
+// address. A disabled limit always allows.
+func Allow(ctx context.Context, buckets repository.BucketRepository, limit Limit, key string) (bool, error) {
+	if limit.Requests <= 0 || limit.Per <= 0 {
+		return true, nil
+	}
+	allowed, _, err := buckets.Take(ctx, limit.Name+":"+key, float64(limit.Requests), limit.rate(), time.Now())
+	return allowed, err
+}
+
+// RateLimit refuses requests beyond limit with 429 Too Many Requests. It can
+// be attached to a single route or a whole group. Every response carries the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and
+// refusals also Retry-After. If the bucket store fails, requests are let
+// through rather than turning an outage of the store into one of the API.
+func RateLimit(buckets repository.BucketRepository, limit Limit, key KeyFunc) gin.HandlerFunc {
+	if limit.Requests <= 0 || limit.Per <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	rate := limit.rate()
+	policy := fmt.Sprintf("%d;w=%d", limit.Requests, int(math.Ceil(limit.Per.Seconds())))
+
+	return func(c *gin.Context) {
+		bucket := limit.Name + ":" + key(c)
+		allowed, tokens, err := buckets.Take(c.Request.Context(), bucket, float64(limit.Requests), rate, time.Now())
+		if err != nil {
+			log.Println("Error checking rate limit:", err)
+			c.Next()
+			return
+		}
+
+		reset := math.Ceil((float64(limit.Requests) - tokens) / rate)
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(int(math.Floor(tokens))))
+		c.Header("RateLimit-Reset", strconv.Itoa(int(reset)))
+		if !allowed {
+			retryAfter := int(math.Ceil((1 - tokens) / rate))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later", "retry_after": retryAfter})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
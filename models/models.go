@@ -1,78 +1,450 @@
 package models
 
 import (
+	"path"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type User struct {
-	ID            primitive.ObjectID `json:"_id" bson:"_id"`
-	Name          *string            `json:"name" validate:"required,min=2,max=30" bson:"name"`
-	Password      *string            `json:"password" validate:"required,min=6" bson:"password"`
-	Email         *string            `json:"email" validate:"email,required" bson:"email"`
-	Role          int                `json:"role" bson:"role"`
-	Avatar        *string            `json:"avatar" bson:"avatar"`
-	AvatarPath    *string            `json:"avatar_path" bson:"avatar_path"`
-	Token         *string            `json:"token" bson:"token"`
-	Refresh_Token *string            `json:"refresh_token" bson:"refresh_token"`
-	User_ID       string             `json:"user_id" bson:"user_id"`
-	Created_At    time.Time          `json:"created_at" bson:"created_at"`
-	Updated_At    time.Time          `json:"updated_at" bson:"updated_at"`
+	ID             primitive.ObjectID `json:"_id" bson:"_id"`
+	Name           *string            `json:"name" validate:"required,min=2,max=30" bson:"name"`
+	Password       *string            `json:"password" validate:"required,min=6,maxbytes=72" bson:"password"`
+	Email          *string            `json:"email" validate:"required,email,max=254" bson:"email"`
+	Role           int                `json:"role" bson:"role"`
+	Avatar         *string            `json:"avatar" bson:"avatar" validate:"omitempty,url"`
+	AvatarPath     *string            `json:"avatar_path" bson:"avatar_path" validate:"omitempty,max=500"`
+	Token          *string            `json:"token" bson:"token"`
+	Refresh_Token  *string            `json:"refresh_token" bson:"refresh_token"`
+	User_ID        string             `json:"user_id" bson:"user_id"`
+	Email_Verified *bool              `json:"email_verified" bson:"email_verified"`
+	Verified_At    *time.Time         `json:"verified_at" bson:"verified_at"`
+	Version        int64              `json:"version" bson:"version"`
+	Created_At     time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At     time.Time          `json:"updated_at" bson:"updated_at"`
+	Deleted_At     *time.Time         `json:"deleted_at" bson:"deleted_at"`
+	Deleted_By     string             `json:"deleted_by" bson:"deleted_by"`
+}
+
+// IsVerified reports whether the user has confirmed their email address.
+// Accounts registered before addresses were verified have no
+// email_verified field and count as verified.
+func (u *User) IsVerified() bool {
+	return u.Email_Verified == nil || *u.Email_Verified
+}
+
+// IsAdmin reports whether the user has one of the roles the admin routes
+// accept.
+func (u *User) IsAdmin() bool {
+	return u.Role == 1 || u.Role == 2
 }
 
 type Service struct {
 	Service_ID  primitive.ObjectID `json:"_id" bson:"_id"`
-	Title       *string            `json:"title" bson:"title"`
-	Description *string            `json:"description" bson:"description"`
-	Image       *string            `json:"image" bson:"image"`
-	ImagePath   *string            `json:"image_path" bson:"image_path"`
-	T1          *string            `json:"t1" bson:"t1"`
-	T2          *string            `json:"t2" bson:"t2"`
+	Title       *string            `json:"title" bson:"title" validate:"required,notblank,max=200"`
+	Description *string            `json:"description" bson:"description" validate:"omitempty,max=5000"`
+	Image       *string            `json:"image" bson:"image" validate:"omitempty,url"`
+	ImagePath   *string            `json:"image_path" bson:"image_path" validate:"omitempty,max=500"`
+	T1          *string            `json:"t1" bson:"t1" validate:"omitempty,max=500"`
+	T2          *string            `json:"t2" bson:"t2" validate:"omitempty,max=500"`
+	Version     int64              `json:"version" bson:"version"`
 	Created_At  time.Time          `json:"created_at" bson:"created_at"`
 	Updated_At  time.Time          `json:"updated_at" bson:"updated_at"`
+	Deleted_At  *time.Time         `json:"deleted_at" bson:"deleted_at"`
+	Deleted_By  string             `json:"deleted_by" bson:"deleted_by"`
 }
 
 type Images struct {
-	Image     *string `json:"image" bson:"image"`
-	ImagePath *string `json:"image_path" bson:"image_path"`
+	Image     *string `json:"image" bson:"image" validate:"omitempty,url"`
+	ImagePath *string `json:"image_path" bson:"image_path" validate:"omitempty,max=500"`
 }
 
 type Project struct {
 	Project_ID  primitive.ObjectID `json:"_id" bson:"_id"`
-	Title       *string            `json:"title" bson:"title"`
-	Description *string            `json:"description" bson:"description"`
-	DemoLink    *string            `json:"demo_link" bson:"demo_link"`
-	Tech        *string            `json:"tech" bson:"tech"`
-	Images      []*Images          `json:"images" bson:"images"`
-	T1          *string            `json:"t1" bson:"t1"`
-	T2          *string            `json:"t2" bson:"t2"`
+	Title       *string            `json:"title" bson:"title" validate:"required,notblank,max=200"`
+	Description *string            `json:"description" bson:"description" validate:"omitempty,max=10000"`
+	DemoLink    *string            `json:"demo_link" bson:"demo_link" validate:"omitempty,url"`
+	Tech        *string            `json:"tech" bson:"tech" validate:"omitempty,max=500"`
+	Images      []*Images          `json:"images" bson:"images" validate:"max=20,dive"`
+	T1          *string            `json:"t1" bson:"t1" validate:"omitempty,max=500"`
+	T2          *string            `json:"t2" bson:"t2" validate:"omitempty,max=500"`
+	Version     int64              `json:"version" bson:"version"`
 	Created_At  time.Time          `json:"created_at" bson:"created_at"`
 	Updated_At  time.Time          `json:"updated_at" bson:"updated_at"`
+	Deleted_At  *time.Time         `json:"deleted_at" bson:"deleted_at"`
+	Deleted_By  string             `json:"deleted_by" bson:"deleted_by"`
 }
 
 type Remark struct {
 	Remark_ID  primitive.ObjectID `json:"_id" bson:"_id"`
-	Name       *string            `json:"name" bson:"name"`
-	Role       *string            `json:"role" bson:"role"`
-	Image      *string            `json:"image" bson:"image"`
-	ImagePath  *string            `json:"image_path" bson:"image_path"`
-	Remark     *string            `json:"remark" bson:"remark"`
-	T1         *string            `json:"t1" bson:"t1"`
-	T2         *string            `json:"t2" bson:"t2"`
+	Name       *string            `json:"name" bson:"name" validate:"required,notblank,max=100"`
+	Role       *string            `json:"role" bson:"role" validate:"omitempty,max=100"`
+	Image      *string            `json:"image" bson:"image" validate:"omitempty,url"`
+	ImagePath  *string            `json:"image_path" bson:"image_path" validate:"omitempty,max=500"`
+	Remark     *string            `json:"remark" bson:"remark" validate:"required,notblank,max=2000"`
+	T1         *string            `json:"t1" bson:"t1" validate:"omitempty,max=500"`
+	T2         *string            `json:"t2" bson:"t2" validate:"omitempty,max=500"`
+	Version    int64              `json:"version" bson:"version"`
 	Created_At time.Time          `json:"created_at" bson:"created_at"`
 	Updated_At time.Time          `json:"updated_at" bson:"updated_at"`
+	Deleted_At *time.Time         `json:"deleted_at" bson:"deleted_at"`
+	Deleted_By string             `json:"deleted_by" bson:"deleted_by"`
 }
 
+// Message is a contact form submission. Status, Assignee_ID, Notes and
+// Tags are kept by admins working through the inbox; messages stored before
+// these existed have no status and count as new. Thread holds the replies
+// sent to the sender, oldest first. Spam_Reasons says why a message was
+// filed as spam and Content_Hash is used to spot repeated submissions.
+// Deliveries lists the emails queued about the message, the notification
+// to our inbox among them, and is filled in when it is read, not stored.
 type Message struct {
-	Message_ID  primitive.ObjectID `json:"_id" bson:"_id"`
-	Name        *string            `json:"name" bson:"name"`
-	Email       *string            `json:"email" bson:"email"      validate:"email,required"`
-	Phone       *string            `json:"phone" bson:"phone"`
-	CompanyName *string            `json:"company_name" bson:"company_name"`
-	Message     *string            `json:"message" bson:"message"`
-	T1          *string            `json:"t1" bson:"t1"`
-	T2          *string            `json:"t2" bson:"t2"`
+	Message_ID   primitive.ObjectID `json:"_id" bson:"_id"`
+	Name         *string            `json:"name" bson:"name" validate:"omitempty,max=100"`
+	Email        *string            `json:"email" bson:"email" validate:"required,email,max=254"`
+	Phone        *string            `json:"phone" bson:"phone" validate:"omitempty,max=40"`
+	CompanyName  *string            `json:"company_name" bson:"company_name" validate:"omitempty,max=200"`
+	Message      *string            `json:"message" bson:"message" validate:"required,notblank,max=10000"`
+	Locale       *string            `json:"locale" bson:"locale" validate:"omitempty,max=35"`
+	T1           *string            `json:"t1" bson:"t1" validate:"omitempty,max=500"`
+	T2           *string            `json:"t2" bson:"t2" validate:"omitempty,max=500"`
+	Status       string             `json:"status" bson:"status"`
+	Assignee_ID  *string            `json:"assignee_id" bson:"assignee_id"`
+	Notes        []Note             `json:"notes" bson:"notes"`
+	Tags         []string           `json:"tags" bson:"tags"`
+	Thread       []Reply            `json:"thread" bson:"thread"`
+	IP           string             `json:"ip" bson:"ip"`
+	Content_Hash string             `json:"-" bson:"content_hash"`
+	Spam_Reasons []string           `json:"spam_reasons" bson:"spam_reasons"`
+	Deliveries   []Delivery         `json:"deliveries" bson:"-"`
+	Created_At   time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At   time.Time          `json:"updated_at" bson:"updated_at"`
+	Deleted_At   *time.Time         `json:"deleted_at" bson:"deleted_at"`
+	Deleted_By   string             `json:"deleted_by" bson:"deleted_by"`
+}
+
+const (
+	MessageNew      = "new"
+	MessageRead     = "read"
+	MessageReplied  = "replied"
+	MessageArchived = "archived"
+	MessageSpam     = "spam"
+)
+
+// Note is an admin's internal comment on a message.
+type Note struct {
+	Note_ID     primitive.ObjectID `json:"_id" bson:"_id"`
+	Author_ID   string             `json:"author_id" bson:"author_id"`
+	Author_Name string             `json:"author_name" bson:"author_name"`
+	Body        string             `json:"body" bson:"body"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Reply is an email an admin sent in answer to a contact message. Mail_ID,
+// In_Reply_To and References are its threading headers and Outbound_ID the
+// outbox entry it was sent through. Status, Attempts and Last_Error are
+// that entry's delivery state and are filled in when the thread is read,
+// not stored.
+type Reply struct {
+	Reply_ID    primitive.ObjectID `json:"_id" bson:"_id"`
+	Author_ID   string             `json:"author_id" bson:"author_id"`
+	Author_Name string             `json:"author_name" bson:"author_name"`
+	To          string             `json:"to" bson:"to"`
+	Subject     string             `json:"subject" bson:"subject"`
+	Body        string             `json:"body" bson:"body"`
+	Mail_ID     string             `json:"mail_id" bson:"mail_id"`
+	In_Reply_To string             `json:"in_reply_to" bson:"in_reply_to"`
+	References  []string           `json:"references" bson:"references"`
+	Outbound_ID primitive.ObjectID `json:"outbound_id" bson:"outbound_id"`
+	Status      string             `json:"status" bson:"-"`
+	Attempts    int                `json:"attempts" bson:"-"`
+	Last_Error  string             `json:"last_error" bson:"-"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Delivery is the delivery state of an email queued about a message, as
+// recorded on its outbox entry.
+type Delivery struct {
+	Outbound_ID primitive.ObjectID `json:"outbound_id"`
+	Kind        string             `json:"kind"`
+	To          string             `json:"to"`
+	Status      string             `json:"status"`
+	Attempts    int                `json:"attempts"`
+	Last_Error  string             `json:"last_error"`
+	Sent_At     *time.Time         `json:"sent_at"`
+	Created_At  time.Time          `json:"created_at"`
+}
+
+// Reference is the number quoted to the sender of a message. It combines the
+// creation time and counter parts of Message_ID, which keeps it short.
+func (m *Message) Reference() string {
+	hex := strings.ToUpper(m.Message_ID.Hex())
+	return "NS-" + hex[:8] + "-" + hex[18:]
+}
+
+const (
+	PostDraft     = "draft"
+	PostPublished = "published"
+)
+
+type Post struct {
+	Post_ID      primitive.ObjectID `json:"_id" bson:"_id"`
+	Title        *string            `json:"title" bson:"title" validate:"required,notblank,max=200"`
+	Slug         string             `json:"slug" bson:"slug" validate:"max=200"`
+	Body         *string            `json:"body" bson:"body" validate:"omitempty,max=100000"`
+	Cover        *Images            `json:"cover" bson:"cover"`
+	Tags         []string           `json:"tags" bson:"tags" validate:"max=20,dive,max=40"`
+	Author_ID    string             `json:"author_id" bson:"author_id"`
+	Status       string             `json:"status" bson:"status" validate:"omitempty,oneof=draft published"`
+	Published_At *time.Time         `json:"published_at" bson:"published_at"`
+	Created_At   time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Document is implemented by the models served through controllers.Resource.
+// Version counts the updates made to a document and identifies each
+// revision of it. The content models are soft deleted: Deleted_At and
+// Deleted_By are set while a document is in the trash. Files lists the
+// uploaded files a document refers to.
+type Document interface {
+	GetID() primitive.ObjectID
+	Files() []string
+	SetID(id primitive.ObjectID)
+	GetVersion() int64
+	SetVersion(v int64)
+	SetCreatedAt(t time.Time)
+	SetUpdatedAt(t time.Time)
+}
+
+func (s *Service) GetID() primitive.ObjectID   { return s.Service_ID }
+func (s *Service) SetID(id primitive.ObjectID) { s.Service_ID = id }
+func (s *Service) GetVersion() int64           { return s.Version }
+func (s *Service) SetVersion(v int64)          { s.Version = v }
+func (s *Service) SetCreatedAt(t time.Time)    { s.Created_At = t }
+func (s *Service) SetUpdatedAt(t time.Time)    { s.Updated_At = t }
+
+func (p *Project) GetID() primitive.ObjectID   { return p.Project_ID }
+func (p *Project) SetID(id primitive.ObjectID) { p.Project_ID = id }
+func (p *Project) GetVersion() int64           { return p.Version }
+func (p *Project) SetVersion(v int64)          { p.Version = v }
+func (p *Project) SetCreatedAt(t time.Time)    { p.Created_At = t }
+func (p *Project) SetUpdatedAt(t time.Time)    { p.Updated_At = t }
+
+func (r *Remark) GetID() primitive.ObjectID   { return r.Remark_ID }
+func (r *Remark) SetID(id primitive.ObjectID) { r.Remark_ID = id }
+func (r *Remark) GetVersion() int64           { return r.Version }
+func (r *Remark) SetVersion(v int64)          { r.Version = v }
+func (r *Remark) SetCreatedAt(t time.Time)    { r.Created_At = t }
+func (r *Remark) SetUpdatedAt(t time.Time)    { r.Updated_At = t }
+
+func (u *User) GetID() primitive.ObjectID { return u.ID }
+
+// AvatarDir is the storage directory of the avatars uploaded for a user.
+func AvatarDir(userID string) string {
+	return "avatars/" + userID
+}
+
+// Files lists the paths of the stored files a document refers to, which
+// are deleted along with it.
+func (s *Service) Files() []string { return storedPaths(s.ImagePath) }
+
+func (r *Remark) Files() []string { return storedPaths(r.ImagePath) }
+
+func (p *Project) Files() []string {
+	var paths []*string
+	for _, image := range p.Images {
+		if image != nil {
+			paths = append(paths, image.ImagePath)
+		}
+	}
+	return storedPaths(paths...)
+}
+
+func (p *Post) Files() []string {
+	if p.Cover == nil {
+		return nil
+	}
+	return storedPaths(p.Cover.ImagePath)
+}
+
+// IsAvatarPath reports whether p is a path inside the avatar directory of
+// the user. It must be in canonical form, so ".." cannot lead it out.
+func IsAvatarPath(userID, p string) bool {
+	return userID != "" && path.Clean(p) == p && strings.HasPrefix(p, AvatarDir(userID)+"/")
+}
+
+// Files returns the user's avatar only if it lies in their avatar
+// directory, since older accounts may have set avatar_path to anything.
+func (u *User) Files() []string {
+	if u.AvatarPath == nil || !IsAvatarPath(u.User_ID, *u.AvatarPath) {
+		return nil
+	}
+	return []string{*u.AvatarPath}
+}
+
+func storedPaths(paths ...*string) []string {
+	var stored []string
+	for _, p := range paths {
+		if p != nil && *p != "" {
+			stored = append(stored, *p)
+		}
+	}
+	return stored
+}
+
+// Session is one refresh token. Every rotation creates a new session in the
+// same family; presenting an already rotated token revokes the family.
+type Session struct {
+	Session_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	Family_ID  string             `json:"family_id" bson:"family_id"`
+	User_ID    string             `json:"user_id" bson:"user_id"`
+	Token_Hash string             `json:"-" bson:"token_hash"`
+	User_Agent string             `json:"user_agent" bson:"user_agent"`
+	IP         string             `json:"ip" bson:"ip"`
+	Rotated_At *time.Time         `json:"rotated_at" bson:"rotated_at"`
+	Revoked_At *time.Time         `json:"revoked_at" bson:"revoked_at"`
+	Expires_At time.Time          `json:"expires_at" bson:"expires_at"`
+	Created_At time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// RevokedToken denylists an access token ID or session family ID until the
+// last access token it could cover has expired.
+type RevokedToken struct {
+	Revoked_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	Token_ID   string             `json:"token_id" bson:"token_id"`
+	Reason     string             `json:"reason" bson:"reason"`
+	Expires_At time.Time          `json:"expires_at" bson:"expires_at"`
+	Created_At time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// PasswordReset is a single-use password reset link. Only the hash of the
+// token sent to the user is stored.
+type PasswordReset struct {
+	Reset_ID   primitive.ObjectID `json:"_id" bson:"_id"`
+	User_ID    string             `json:"user_id" bson:"user_id"`
+	Token_Hash string             `json:"-" bson:"token_hash"`
+	IP         string             `json:"ip" bson:"ip"`
+	Used_At    *time.Time         `json:"used_at" bson:"used_at"`
+	Expires_At time.Time          `json:"expires_at" bson:"expires_at"`
+	Created_At time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// LoginAttempt counts recent failed logins for one key, an account
+// ("account:<email>") or a client address ("ip:<address>"). The counter
+// expires a fixed window after the first failure, or when its lock ends.
+type LoginAttempt struct {
+	Attempt_ID      primitive.ObjectID `json:"_id" bson:"_id"`
+	Key             string             `json:"key" bson:"key"`
+	Failures        int                `json:"failures" bson:"failures"`
+	Locked_Until    *time.Time         `json:"locked_until" bson:"locked_until"`
+	Last_Failure_At time.Time          `json:"last_failure_at" bson:"last_failure_at"`
+	Expires_At      time.Time          `json:"expires_at" bson:"expires_at"`
+	Created_At      time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Lockout is the audit record of an account or address being locked out
+// after too many failed logins.
+type Lockout struct {
+	Lockout_ID   primitive.ObjectID `json:"_id" bson:"_id"`
+	Key          string             `json:"key" bson:"key"`
+	Email        string             `json:"email" bson:"email"`
+	User_ID      string             `json:"user_id" bson:"user_id"`
+	IP           string             `json:"ip" bson:"ip"`
+	User_Agent   string             `json:"user_agent" bson:"user_agent"`
+	Failures     int                `json:"failures" bson:"failures"`
+	Locked_Until time.Time          `json:"locked_until" bson:"locked_until"`
+	Unlocked_At  *time.Time         `json:"unlocked_at" bson:"unlocked_at"`
+	Unlocked_By  string             `json:"unlocked_by" bson:"unlocked_by"`
+	Created_At   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// RateLimitBucket is a token bucket. Version guards concurrent updates and
+// Expires_At is when the bucket is full again, after which it is dropped.
+type RateLimitBucket struct {
+	Bucket_ID  primitive.ObjectID `json:"_id" bson:"_id"`
+	Key        string             `json:"key" bson:"key"`
+	Tokens     float64            `json:"tokens" bson:"tokens"`
+	Version    int64              `json:"version" bson:"version"`
+	Updated_At time.Time          `json:"updated_at" bson:"updated_at"`
+	Expires_At time.Time          `json:"expires_at" bson:"expires_at"`
+}
+
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboundEmail is a message waiting in, or delivered from, the outbox.
+// Body is the HTML part and Text the optional plain-text alternative.
+// Message_ID links notifications about a contact message to it, while
+// Mail_ID, In_Reply_To and References are the email's threading headers.
+// The bodies of a Sensitive email, which hold a secret link, are cleared
+// once sent.
+type OutboundEmail struct {
+	Outbound_ID     primitive.ObjectID `json:"_id" bson:"_id"`
+	Kind            string             `json:"kind" bson:"kind"`
+	To              string             `json:"to" bson:"to"`
+	Subject         string             `json:"subject" bson:"subject"`
+	Body            string             `json:"body" bson:"body"`
+	Text            string             `json:"text" bson:"text"`
+	Sensitive       bool               `json:"sensitive" bson:"sensitive"`
+	Message_ID      string             `json:"message_id" bson:"message_id"`
+	Mail_ID         string             `json:"mail_id" bson:"mail_id"`
+	In_Reply_To     string             `json:"in_reply_to" bson:"in_reply_to"`
+	References      []string           `json:"references" bson:"references"`
+	Status          string             `json:"status" bson:"status"`
+	Attempts        int                `json:"attempts" bson:"attempts"`
+	Max_Attempts    int                `json:"max_attempts" bson:"max_attempts"`
+	Last_Error      string             `json:"last_error" bson:"last_error"`
+	Next_Attempt_At time.Time          `json:"next_attempt_at" bson:"next_attempt_at"`
+	Locked_Until    *time.Time         `json:"locked_until" bson:"locked_until"`
+	Sent_At         *time.Time         `json:"sent_at" bson:"sent_at"`
+	Created_At      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// EmailTemplate is an admin's replacement for one of the built-in email
+// templates, or its translation when Locale is set. Subject and Text are
+// text/template sources, HTML an html/template source.
+type EmailTemplate struct {
+	Template_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	Name        string             `json:"name" bson:"name"`
+	Locale      string             `json:"locale" bson:"locale"`
+	Subject     string             `json:"subject" bson:"subject"`
+	HTML        string             `json:"html" bson:"html"`
+	Text        string             `json:"text" bson:"text"`
+	Updated_By  string             `json:"updated_by" bson:"updated_by"`
 	Created_At  time.Time          `json:"created_at" bson:"created_at"`
 	Updated_At  time.Time          `json:"updated_at" bson:"updated_at"`
 }
+
+// AuditEntry records a request an admin made. Action is the method and
+// route, such as "PUT /admin/update-user-role". Changes lists the fields
+// the request changed, when the handler reported them.
+type AuditEntry struct {
+	Audit_ID    primitive.ObjectID `json:"_id" bson:"_id"`
+	Actor_ID    string             `json:"actor_id" bson:"actor_id"`
+	Actor_Email string             `json:"actor_email" bson:"actor_email"`
+	Action      string             `json:"action" bson:"action"`
+	Method      string             `json:"method" bson:"method"`
+	Path        string             `json:"path" bson:"path"`
+	Resource    string             `json:"resource" bson:"resource"`
+	Resource_ID string             `json:"resource_id" bson:"resource_id"`
+	Changes     []FieldChange      `json:"changes" bson:"changes"`
+	Status      int                `json:"status" bson:"status"`
+	IP          string             `json:"ip" bson:"ip"`
+	User_Agent  string             `json:"user_agent" bson:"user_agent"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// FieldChange is the value of one field before and after a change. A
+// field that was empty or missing is nil on that side.
+type FieldChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
@@ -0,0 +1,312 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to JSON values decoded into interface{}, that is
+// maps, slices, strings, float64s, bools and nil.
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media types of the two patch formats.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var (
+	// ErrTestFailed is returned when a "test" operation does not match.
+	ErrTestFailed = errors.New("test operation failed")
+	// ErrPathNotFound is returned for a path that does not exist in the
+	// document, or an array index out of range.
+	ErrPathNotFound = errors.New("path not found")
+	// ErrInvalid is returned for a malformed patch.
+	ErrInvalid = errors.New("invalid patch")
+)
+
+// Error reports the operation of a JSON Patch that could not be applied.
+type Error struct {
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %v", e.Index, e.Op, e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Merge applies a merge patch to doc: members of patch replace those of
+// doc, nested objects are merged and null removes a member. doc is not
+// modified.
+func Merge(doc, patch interface{}) interface{} {
+	fields, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	target, ok := doc.(map[string]interface{})
+	if !ok {
+		target = map[string]interface{}{}
+	}
+	merged := make(map[string]interface{}, len(target))
+	for name, value := range target {
+		merged[name] = value
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(merged, name)
+			continue
+		}
+		merged[name] = Merge(merged[name], value)
+	}
+	return merged
+}
+
+// Operation is one step of a JSON Patch.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Paths lists the paths an operation changes: Path, and From when it is
+// a move.
+func (o Operation) Paths() []string {
+	if o.Op == "move" {
+		return []string{o.From, o.Path}
+	}
+	if o.Op == "test" {
+		return nil
+	}
+	return []string{o.Path}
+}
+
+// Apply applies ops to doc in order. Either all of them succeed or an
+// *Error describes the first that failed. doc is not modified.
+func Apply(doc interface{}, ops []Operation) (interface{}, error) {
+	doc = clone(doc)
+	for i, op := range ops {
+		var err error
+		doc, err = apply(doc, op)
+		if err != nil {
+			return nil, &Error{Index: i, Op: op.Op, Path: op.Path, Err: err}
+		}
+	}
+	return doc, nil
+}
+
+func apply(doc interface{}, op Operation) (interface{}, error) {
+	path, err := ParsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "test" {
+			current, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, ErrTestFailed
+			}
+			return doc, nil
+		}
+		return set(doc, path, value, op.Op == "add")
+	case "remove":
+		doc, _, err := remove(doc, path)
+		return doc, err
+	case "move", "copy":
+		from, err := ParsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if op.Op == "move" {
+			if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+				return nil, fmt.Errorf("%w: cannot move a value into itself", ErrInvalid)
+			}
+			doc, value, err = remove(doc, from)
+		} else {
+			value, err = get(doc, from)
+			value = clone(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return set(doc, path, value, true)
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", ErrInvalid, op.Op)
+}
+
+func (o Operation) value() (interface{}, error) {
+	if len(o.Value) == 0 {
+		return nil, fmt.Errorf("%w: %s requires a value", ErrInvalid, o.Op)
+	}
+	var value interface{}
+	if err := json.Unmarshal(o.Value, &value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return value, nil
+}
+
+// ParsePointer splits a JSON Pointer (RFC 6901) such as "/images/0/image"
+// into its unescaped reference tokens. The empty pointer is the whole
+// document.
+func ParsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: pointer %q does not start with /", ErrInvalid, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, ErrPathNotFound
+			}
+			doc = value
+		case []interface{}:
+			i, err := index(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, ErrPathNotFound
+		}
+	}
+	return doc, nil
+}
+
+// set stores value at path. When insert is true it adds to arrays, and to
+// objects whether or not the member exists; otherwise the location must
+// already exist and is replaced.
+func set(doc interface{}, path []string, value interface{}, insert bool) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := node[last]; !ok && !insert {
+			return nil, ErrPathNotFound
+		}
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		if !insert {
+			i, err := index(last, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			node[i] = value
+			return doc, nil
+		}
+		i := len(node)
+		if last != "-" {
+			if i, err = index(last, len(node)); err != nil {
+				return nil, err
+			}
+		}
+		grown := append(node[:i:i], value)
+		grown = append(grown, node[i:]...)
+		return replaceParent(doc, path[:len(path)-1], grown)
+	}
+	return nil, ErrPathNotFound
+}
+
+// remove deletes the value at path, returning the new document and the
+// value removed.
+func remove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalid)
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, ErrPathNotFound
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []interface{}:
+		i, err := index(last, len(node)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[i]
+		shrunk := append(node[:i:i], node[i+1:]...)
+		doc, err = replaceParent(doc, path[:len(path)-1], shrunk)
+		return doc, value, err
+	}
+	return nil, nil, ErrPathNotFound
+}
+
+// replaceParent stores a resized array back at path, since growing or
+// shrinking a slice creates a new one.
+func replaceParent(doc interface{}, path []string, array []interface{}) (interface{}, error) {
+	return set(doc, path, array, false)
+}
+
+// index parses an array index no greater than max.
+func index(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrPathNotFound
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max {
+		return 0, ErrPathNotFound
+	}
+	return i, nil
+}
+
+func clone(value interface{}) interface{} {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(node))
+		for name, v := range node {
+			copied[name] = clone(v)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(node))
+		for i, v := range node {
+			copied[i] = clone(v)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,170 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decoding %s: %v", s, err)
+	}
+	return v
+}
+
+// The examples of RFC 7396, Appendix A.
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		doc := decode(t, tt.doc)
+		got := Merge(doc, decode(t, tt.patch))
+		if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("Merge(%s, %s) = %v, want %v", tt.doc, tt.patch, got, want)
+		}
+		if !reflect.DeepEqual(doc, decode(t, tt.doc)) {
+			t.Errorf("Merge(%s, %s) modified the document", tt.doc, tt.patch)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		// RFC 6902, Appendix A.
+		{"add an object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, nil},
+		{"add an array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{"remove an object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{"remove an array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{"replace a value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, nil},
+		{"move a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil},
+		{"move an array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, nil},
+		{"test a value", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`, nil},
+		{"test a value error", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", ErrTestFailed},
+		{"add a nested member object", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`, nil},
+		{"ignore unrecognized elements", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`, nil},
+		{"add to a nonexistent target", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", ErrPathNotFound},
+		{"~ escape ordering", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, nil},
+		{"compare strings and numbers", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, "", ErrTestFailed},
+		{"add an array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, nil},
+
+		{"append with -", `{"tags":["a"]}`, `[{"op":"add","path":"/tags/-","value":"b"},{"op":"add","path":"/tags/-","value":"c"}]`, `{"tags":["a","b","c"]}`, nil},
+		{"append to the root array", `["a"]`, `[{"op":"add","path":"/-","value":"b"}]`, `["a","b"]`, nil},
+		{"- only adds", `{"tags":["a"]}`, `[{"op":"replace","path":"/tags/-","value":"b"}]`, "", ErrPathNotFound},
+		{"- cannot be removed", `{"tags":["a"]}`, `[{"op":"remove","path":"/tags/-"}]`, "", ErrPathNotFound},
+		{"add at the end index", `{"tags":["a"]}`, `[{"op":"add","path":"/tags/1","value":"b"}]`, `{"tags":["a","b"]}`, nil},
+		{"add past the end", `{"tags":["a"]}`, `[{"op":"add","path":"/tags/2","value":"b"}]`, "", ErrPathNotFound},
+		{"replace past the end", `{"tags":["a"]}`, `[{"op":"replace","path":"/tags/1","value":"b"}]`, "", ErrPathNotFound},
+		{"remove past the end", `{"tags":["a"]}`, `[{"op":"remove","path":"/tags/1"}]`, "", ErrPathNotFound},
+		{"negative index", `{"tags":["a"]}`, `[{"op":"replace","path":"/tags/-1","value":"b"}]`, "", ErrPathNotFound},
+		{"leading zero", `{"tags":["a","b"]}`, `[{"op":"replace","path":"/tags/01","value":"c"}]`, "", ErrPathNotFound},
+		{"index zero", `{"tags":["a","b"]}`, `[{"op":"replace","path":"/tags/0","value":"c"}]`, `{"tags":["c","b"]}`, nil},
+		{"test equal numbers", `{"n":1}`, `[{"op":"test","path":"/n","value":1.0}]`, `{"n":1}`, nil},
+		{"test different numbers", `{"n":1}`, `[{"op":"test","path":"/n","value":1.5}]`, "", ErrTestFailed},
+		{"test a number against a string", `{"n":1}`, `[{"op":"test","path":"/n","value":"1"}]`, "", ErrTestFailed},
+		{"test a missing member", `{}`, `[{"op":"test","path":"/n","value":1}]`, "", ErrPathNotFound},
+		{"test null", `{"n":null}`, `[{"op":"test","path":"/n","value":null}]`, `{"n":null}`, nil},
+		{"replace a missing member", `{}`, `[{"op":"replace","path":"/n","value":1}]`, "", ErrPathNotFound},
+		{"replace the whole document", `{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`, nil},
+		{"remove the whole document", `{"a":1}`, `[{"op":"remove","path":""}]`, "", ErrInvalid},
+		{"move into itself", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/c"}]`, "", ErrInvalid},
+		{"move to itself", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":{"b":1}}`, nil},
+		{"move to a sibling with a prefix", `{"a":1}`, `[{"op":"move","from":"/a","path":"/ab"}]`, `{"ab":1}`, nil},
+		{"copy", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`, nil},
+		{"copy a missing value", `{}`, `[{"op":"copy","from":"/a","path":"/c"}]`, "", ErrPathNotFound},
+		{"add without a value", `{}`, `[{"op":"add","path":"/a"}]`, "", ErrInvalid},
+		{"add null", `{}`, `[{"op":"add","path":"/a","value":null}]`, `{"a":null}`, nil},
+		{"unknown op", `{}`, `[{"op":"merge","path":"/a","value":1}]`, "", ErrInvalid},
+		{"pointer without a slash", `{"a":1}`, `[{"op":"remove","path":"a"}]`, "", ErrInvalid},
+		{"a failed op undoes the rest", `{"a":1}`, `[{"op":"remove","path":"/a"},{"op":"test","path":"/a","value":1}]`, "", ErrPathNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []Operation
+			if err := json.Unmarshal([]byte(tt.patch), &ops); err != nil {
+				t.Fatal(err)
+			}
+			doc := decode(t, tt.doc)
+			got, err := Apply(doc, ops)
+			if !reflect.DeepEqual(doc, decode(t, tt.doc)) {
+				t.Errorf("Apply modified the document to %v", doc)
+			}
+			if tt.wantErr != nil {
+				var patchErr *Error
+				if !errors.Is(err, tt.wantErr) || !errors.As(err, &patchErr) {
+					t.Fatalf("Apply = %v, %v; want an *Error wrapping %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("Apply = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    []string
+	}{
+		{"", nil},
+		{"/", []string{""}},
+		{"/foo/0", []string{"foo", "0"}},
+		{"/a~1b", []string{"a/b"}},
+		{"/m~0n", []string{"m~n"}},
+		{"/~01", []string{"~1"}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePointer(tt.pointer)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePointer(%q) = %q, %v; want %q", tt.pointer, got, err, tt.want)
+		}
+	}
+}
+
+func TestOperationPaths(t *testing.T) {
+	tests := []struct {
+		op   Operation
+		want []string
+	}{
+		{Operation{Op: "add", Path: "/a"}, []string{"/a"}},
+		{Operation{Op: "copy", From: "/b", Path: "/a"}, []string{"/a"}},
+		{Operation{Op: "move", From: "/b", Path: "/a"}, []string{"/b", "/a"}},
+		{Operation{Op: "test", Path: "/a"}, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.op.Paths(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s Paths() = %q, want %q", tt.op.Op, got, tt.want)
+		}
+	}
+}
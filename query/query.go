@@ -0,0 +1,271 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultLimit int64 = 20
+	MaxLimit     int64 = 100
+)
+
+// Kind tells Parse how to convert a filter value taken from the query string.
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Bool
+	Time
+	ObjectID
+)
+
+// Spec describes which fields a get-all endpoint can be sorted and filtered by.
+type Spec struct {
+	Sortable    []string
+	Filterable  map[string]Kind
+	DefaultSort []SortField
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Condition is a single field filter such as created_at[gte]=2024-01-01.
+type Condition struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// List is a parsed get-all request: filters, ordering and the page to return.
+type List struct {
+	Conditions []Condition
+	Sort       []SortField
+	After      *primitive.ObjectID
+	Page       int64
+	Limit      int64
+}
+
+// Page is the envelope returned by every get-all endpoint.
+type Page[T any] struct {
+	Items      []T     `json:"items"`
+	Total      int64   `json:"total"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+var operators = map[string]string{
+	"eq":  "$eq",
+	"ne":  "$ne",
+	"gt":  "$gt",
+	"gte": "$gte",
+	"lt":  "$lt",
+	"lte": "$lte",
+	"in":  "$in",
+}
+
+var reserved = map[string]bool{"page": true, "limit": true, "sort": true, "after": true}
+
+// Parse reads page, limit, after, sort and field filters from values.
+// Parameters that are neither reserved nor filterable are ignored.
+func Parse(values url.Values, spec Spec) (List, error) {
+	list := List{Page: 1, Limit: DefaultLimit, Sort: spec.DefaultSort}
+
+	if v := values.Get("page"); v != "" {
+		page, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || page < 1 {
+			return list, errors.New("page must be a positive integer")
+		}
+		list.Page = page
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 {
+			return list, errors.New("limit must be a positive integer")
+		}
+		if limit > MaxLimit {
+			limit = MaxLimit
+		}
+		list.Limit = limit
+	}
+
+	if v := values.Get("sort"); v != "" {
+		list.Sort = nil
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			desc := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			if !sortable(spec, field) {
+				return list, fmt.Errorf("cannot sort by %q", field)
+			}
+			list.Sort = append(list.Sort, SortField{Field: field, Desc: desc})
+		}
+	}
+
+	if v := values.Get("after"); v != "" {
+		after, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return list, errors.New("after must be an object ID")
+		}
+		if !list.SortedByID() {
+			return list, errors.New("after can only be combined with sort=_id or sort=-_id")
+		}
+		list.After = &after
+	}
+
+	for key, vals := range values {
+		if reserved[key] || len(vals) == 0 {
+			continue
+		}
+		field, op := key, "eq"
+		if i := strings.Index(key, "["); i > 0 && strings.HasSuffix(key, "]") {
+			field, op = key[:i], key[i+1:len(key)-1]
+		}
+		kind, ok := spec.Filterable[field]
+		if !ok {
+			continue
+		}
+		mongoOp, ok := operators[op]
+		if !ok {
+			return list, fmt.Errorf("unknown filter operator %q", op)
+		}
+		var value interface{}
+		if op == "in" {
+			var items []interface{}
+			for _, raw := range strings.Split(vals[0], ",") {
+				item, err := convert(kind, raw)
+				if err != nil {
+					return list, fmt.Errorf("invalid value for %s: %v", field, err)
+				}
+				items = append(items, item)
+			}
+			value = items
+		} else {
+			item, err := convert(kind, vals[0])
+			if err != nil {
+				return list, fmt.Errorf("invalid value for %s: %v", field, err)
+			}
+			value = item
+		}
+		list.Conditions = append(list.Conditions, Condition{Field: field, Op: mongoOp, Value: value})
+	}
+
+	return list, nil
+}
+
+func sortable(spec Spec, field string) bool {
+	if field == "_id" {
+		return true
+	}
+	for _, f := range spec.Sortable {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func convert(kind Kind, raw string) (interface{}, error) {
+	switch kind {
+	case Int:
+		return strconv.ParseInt(raw, 10, 64)
+	case Bool:
+		return strconv.ParseBool(raw)
+	case Time:
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02", raw)
+	case ObjectID:
+		return primitive.ObjectIDFromHex(raw)
+	default:
+		return raw, nil
+	}
+}
+
+// SortedByID reports whether the list is ordered by _id alone, which is
+// the only ordering cursor pagination supports.
+func (l List) SortedByID() bool {
+	return len(l.Sort) == 0 || (len(l.Sort) == 1 && l.Sort[0].Field == "_id")
+}
+
+func (l List) descending() bool {
+	return len(l.Sort) == 1 && l.Sort[0].Desc
+}
+
+// Filter combines base with the parsed conditions. The cursor condition is
+// only added when withCursor is set, so the same filter can be used for totals.
+func (l List) Filter(base bson.M, withCursor bool) bson.M {
+	var clauses []bson.M
+	if len(base) > 0 {
+		clauses = append(clauses, base)
+	}
+	for _, cond := range l.Conditions {
+		clauses = append(clauses, bson.M{cond.Field: bson.M{cond.Op: cond.Value}})
+	}
+	if withCursor && l.After != nil {
+		op := "$gt"
+		if l.descending() {
+			op = "$lt"
+		}
+		clauses = append(clauses, bson.M{"_id": bson.M{op: *l.After}})
+	}
+	switch len(clauses) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return clauses[0]
+	}
+	return bson.M{"$and": clauses}
+}
+
+// SortOrder returns the sort fields with an _id tie-breaker appended, so
+// the order stays stable between pages when sort values tie.
+func (l List) SortOrder() []SortField {
+	order := append([]SortField{}, l.Sort...)
+	if !l.SortedByID() || len(order) == 0 {
+		order = append(order, SortField{Field: "_id"})
+	}
+	return order
+}
+
+// Skip is the number of documents before the requested page. Cursor
+// requests start right after the cursor instead. A zero Limit means no limit.
+func (l List) Skip() int64 {
+	if l.After != nil || l.Page < 2 {
+		return 0
+	}
+	return (l.Page - 1) * l.Limit
+}
+
+func (l List) FindOptions() *options.FindOptions {
+	sort := bson.D{}
+	for _, s := range l.SortOrder() {
+		dir := 1
+		if s.Desc {
+			dir = -1
+		}
+		sort = append(sort, bson.E{Key: s.Field, Value: dir})
+	}
+	return options.Find().SetSort(sort).SetLimit(l.Limit).SetSkip(l.Skip())
+}
+
+// NextCursor returns the cursor for the page after one holding count items
+// that ended with last, or nil when cursor pagination does not apply.
+func (l List) NextCursor(count int, last primitive.ObjectID) *string {
+	if l.Limit == 0 || !l.SortedByID() || int64(count) < l.Limit || last.IsZero() {
+		return nil
+	}
+	next := last.Hex()
+	return &next
+}
@@ -0,0 +1,259 @@
+package query
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testSpec = Spec{
+	Sortable: []string{"title", "created_at"},
+	Filterable: map[string]Kind{
+		"title":      String,
+		"role":       Int,
+		"active":     Bool,
+		"created_at": Time,
+		"owner_id":   ObjectID,
+	},
+	DefaultSort: []SortField{{Field: "created_at", Desc: true}},
+}
+
+func TestParse(t *testing.T) {
+	id := primitive.NewObjectID()
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	instant := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    List
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  List{Page: 1, Limit: DefaultLimit, Sort: testSpec.DefaultSort},
+		},
+		{
+			name:  "page and limit",
+			query: "page=3&limit=50",
+			want:  List{Page: 3, Limit: 50, Sort: testSpec.DefaultSort},
+		},
+		{
+			name:  "limit is clamped",
+			query: "limit=1000",
+			want:  List{Page: 1, Limit: MaxLimit, Sort: testSpec.DefaultSort},
+		},
+		{name: "page zero", query: "page=0", wantErr: true},
+		{name: "page not a number", query: "page=x", wantErr: true},
+		{name: "negative limit", query: "limit=-1", wantErr: true},
+		{
+			name:  "sort",
+			query: "sort=title,-created_at",
+			want:  List{Page: 1, Limit: DefaultLimit, Sort: []SortField{{Field: "title"}, {Field: "created_at", Desc: true}}},
+		},
+		{
+			name:  "sort by _id",
+			query: "sort=-_id",
+			want:  List{Page: 1, Limit: DefaultLimit, Sort: []SortField{{Field: "_id", Desc: true}}},
+		},
+		{name: "unsortable field", query: "sort=password", wantErr: true},
+		{
+			name:  "after with _id sort",
+			query: "sort=_id&after=" + id.Hex(),
+			want:  List{Page: 1, Limit: DefaultLimit, Sort: []SortField{{Field: "_id"}}, After: &id},
+		},
+		{name: "after with the default sort", query: "after=" + id.Hex(), wantErr: true},
+		{name: "after with another sort", query: "sort=title&after=" + id.Hex(), wantErr: true},
+		{name: "after not an ID", query: "sort=_id&after=x", wantErr: true},
+		{
+			name:  "equality filter",
+			query: "title=Shop",
+			want: List{Page: 1, Limit: DefaultLimit, Sort: testSpec.DefaultSort,
+				Conditions: []Condition{{Field: "title", Op: "$eq", Value: "Shop"}}},
+		},
+		{
+			name:  "operator filter",
+			query: "role[gte]=2",
+			want: List{Page: 1, Limit: DefaultLimit, Sort: testSpec.DefaultSort,
+				Conditions: []Condition{{Field: "role", Op: "$gte", Value: int64(2)}}},
+		},
+		{
+			name:  "in list",
+			query: "role[in]=1,2,3",
+			want: List{Page: 1, Limit: DefaultLimit, Sort: testSpec.DefaultSort,
+				Conditions: []Condition{{Field: "role", Op: "$in", Value: []interface{}{int64(1), int64(2), int64(3)}}}},
+		},
+		{name: "in list with a bad item", query: "role[in]=1,x", wantErr: true},
+		{
+			name:  "bool",
+			query: "active=true",
+			want: List{Page: 1, Limit: DefaultLimit, Sort: testSpec.DefaultSort,
+				Conditions: []Condition{{Field: "active", Op: "$eq", Value: true}}},
+		},
+		{
+			name:  "plain date",
+			query: "created_at[lt]=2024-01-02",
+			want: List{Page: 1, Limit: DefaultLimit, Sort: testSpec.DefaultSort,
+				Conditions: []Condition{{Field: "created_at", Op: "$lt", Value: day}}},
+		},
+		{
+			name:  "RFC 3339 time",
+			query: "created_at[gt]=" + url.QueryEscape("2024-01-02T15:04:05Z"),
+			want: List{Page: 1, Limit: DefaultLimit, Sort: testSpec.DefaultSort,
+				Conditions: []Condition{{Field: "created_at", Op: "$gt", Value: instant}}},
+		},
+		{name: "bad time", query: "created_at=yesterday", wantErr: true},
+		{
+			name:  "object ID",
+			query: "owner_id=" + id.Hex(),
+			want: List{Page: 1, Limit: DefaultLimit, Sort: testSpec.DefaultSort,
+				Conditions: []Condition{{Field: "owner_id", Op: "$eq", Value: id}}},
+		},
+		{name: "bad object ID", query: "owner_id=x", wantErr: true},
+		{name: "bad int", query: "role=admin", wantErr: true},
+		{name: "unknown operator", query: "title[regex]=S", wantErr: true},
+		{
+			name:  "unknown fields are ignored",
+			query: "password=x&password[regex]=y",
+			want:  List{Page: 1, Limit: DefaultLimit, Sort: testSpec.DefaultSort},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Parse(values, testSpec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %+v, want an error", tt.query, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+	title := Condition{Field: "title", Op: "$eq", Value: "Shop"}
+
+	tests := []struct {
+		name       string
+		list       List
+		base       bson.M
+		withCursor bool
+		want       bson.M
+	}{
+		{"nothing", List{}, nil, true, bson.M{}},
+		{"base only", List{}, bson.M{"status": "new"}, true, bson.M{"status": "new"}},
+		{"condition only", List{Conditions: []Condition{title}}, nil, true, bson.M{"title": bson.M{"$eq": "Shop"}}},
+		{
+			"base and condition",
+			List{Conditions: []Condition{title}},
+			bson.M{"status": "new"},
+			true,
+			bson.M{"$and": []bson.M{{"status": "new"}, {"title": bson.M{"$eq": "Shop"}}}},
+		},
+		{"ascending cursor", List{After: &id}, nil, true, bson.M{"_id": bson.M{"$gt": id}}},
+		{
+			"descending cursor",
+			List{After: &id, Sort: []SortField{{Field: "_id", Desc: true}}},
+			nil,
+			true,
+			bson.M{"_id": bson.M{"$lt": id}},
+		},
+		{"cursor left out for totals", List{After: &id}, nil, false, bson.M{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.list.Filter(tt.base, tt.withCursor); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Filter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		sort []SortField
+		want []SortField
+	}{
+		{"unsorted", nil, []SortField{{Field: "_id"}}},
+		{"by _id", []SortField{{Field: "_id", Desc: true}}, []SortField{{Field: "_id", Desc: true}}},
+		{"tie-breaker", []SortField{{Field: "title"}}, []SortField{{Field: "title"}, {Field: "_id"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (List{Sort: tt.sort}).SortOrder(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SortOrder = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkip(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name string
+		list List
+		want int64
+	}{
+		{"first page", List{Page: 1, Limit: 20}, 0},
+		{"third page", List{Page: 3, Limit: 20}, 40},
+		{"cursor", List{Page: 3, Limit: 20, After: &id}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.list.Skip(); got != tt.want {
+				t.Errorf("Skip = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	last := primitive.NewObjectID()
+	byTitle := []SortField{{Field: "title"}}
+
+	tests := []struct {
+		name  string
+		list  List
+		count int
+		last  primitive.ObjectID
+		want  bool
+	}{
+		{"full page", List{Limit: 2}, 2, last, true},
+		{"short page", List{Limit: 2}, 1, last, false},
+		{"no limit", List{}, 5, last, false},
+		{"sorted by another field", List{Limit: 2, Sort: byTitle}, 2, last, false},
+		{"no last ID", List{Limit: 2}, 2, primitive.NilObjectID, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.list.NextCursor(tt.count, tt.last)
+			if !tt.want {
+				if got != nil {
+					t.Errorf("NextCursor = %s, want nil", *got)
+				}
+				return
+			}
+			if got == nil || *got != tt.last.Hex() {
+				t.Errorf("NextCursor = %v, want %s", got, tt.last.Hex())
+			}
+		})
+	}
+}
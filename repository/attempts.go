@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"nanosoft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type LoginAttemptRepository interface {
+	// Get returns the live failure counter for key.
+	Get(ctx context.Context, key string) (*models.LoginAttempt, error)
+	// RecordFailure counts a failed login against key, starting a new
+	// counter that lives for window if there is no live one.
+	RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (*models.LoginAttempt, error)
+	// Lock refuses logins for key until the given time.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Clear forgets every failure counted against key.
+	Clear(ctx context.Context, key string) error
+}
+
+type loginAttemptRepository struct {
+	c Collection[models.LoginAttempt]
+}
+
+func (r *loginAttemptRepository) Get(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	return r.c.FindOne(ctx, bson.M{"key": key, "expires_at": bson.M{"$gt": time.Now()}})
+}
+
+func (r *loginAttemptRepository) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	live := bson.M{"key": key, "expires_at": bson.M{"$gt": at}}
+	increment := bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"last_failure_at": at}}
+	// Two attempts: if a concurrent request inserted the counter first, the
+	// unique index on key rejects ours and the second pass increments theirs.
+	for attempt := 0; attempt < 2; attempt++ {
+		err := r.c.UpdateOne(ctx, live, increment)
+		if err == nil {
+			return r.c.FindOne(ctx, bson.M{"key": key})
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if _, err := r.c.DeleteMany(ctx, bson.M{"key": key}); err != nil {
+			return nil, err
+		}
+		counter := models.LoginAttempt{
+			Attempt_ID:      primitive.NewObjectID(),
+			Key:             key,
+			Failures:        1,
+			Last_Failure_At: at,
+			Expires_At:      at.Add(window),
+			Created_At:      at,
+		}
+		if err := r.c.InsertOne(ctx, &counter); err == nil {
+			return &counter, nil
+		}
+	}
+	return nil, errors.New("could not record login failure for " + key)
+}
+
+func (r *loginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	counter, err := r.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	set := bson.M{"locked_until": until}
+	if until.After(counter.Expires_At) {
+		set["expires_at"] = until
+	}
+	return r.c.UpdateOne(ctx, bson.M{"_id": counter.Attempt_ID}, bson.M{"$set": set})
+}
+
+func (r *loginAttemptRepository) Clear(ctx context.Context, key string) error {
+	_, err := r.c.DeleteMany(ctx, bson.M{"key": key})
+	return err
+}
+
+type LockoutRepository interface {
+	Repository[models.Lockout]
+	// Unlock records that the open lockouts of key were lifted by an admin.
+	Unlock(ctx context.Context, key, by string, at time.Time) error
+}
+
+type lockoutRepository struct {
+	crud[models.Lockout]
+}
+
+func (r *lockoutRepository) Unlock(ctx context.Context, key, by string, at time.Time) error {
+	_, err := r.c.UpdateMany(ctx,
+		bson.M{"key": key, "unlocked_at": nil, "locked_until": bson.M{"$gt": at}},
+		bson.M{"$set": bson.M{"unlocked_at": at, "unlocked_by": by}})
+	return err
+}
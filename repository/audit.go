@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"context"
+
+	"nanosoft/models"
+	"nanosoft/query"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// AuditRepository is the append-only audit log. Entries can be added and
+// listed, never changed or deleted.
+type AuditRepository interface {
+	Append(ctx context.Context, entry *models.AuditEntry) error
+	List(ctx context.Context, filter bson.M, list query.List) (query.Page[models.AuditEntry], error)
+}
+
+type auditRepository struct {
+	c Collection[models.AuditEntry]
+}
+
+func (r *auditRepository) Append(ctx context.Context, entry *models.AuditEntry) error {
+	return r.c.InsertOne(ctx, entry)
+}
+
+func (r *auditRepository) List(ctx context.Context, filter bson.M, list query.List) (query.Page[models.AuditEntry], error) {
+	return r.c.Find(ctx, filter, list)
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"nanosoft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BucketRepository stores token buckets for rate limiting. Kept in MongoDB
+// the buckets are shared by every replica of the API.
+type BucketRepository interface {
+	// Take refills the bucket for key at rate tokens per second, up to
+	// capacity, and removes one token if there is one. It returns whether
+	// a token was taken and how many are left.
+	Take(ctx context.Context, key string, capacity, rate float64, at time.Time) (bool, float64, error)
+}
+
+// NewMemoryBuckets returns buckets local to this process, for deployments
+// that use MongoDB but do not need limits shared between replicas.
+func NewMemoryBuckets() BucketRepository {
+	return &memoryBuckets{buckets: map[string]*models.RateLimitBucket{}}
+}
+
+// refill returns the tokens in bucket at time at, and when it will be full
+// again once one of them is taken.
+func refill(bucket *models.RateLimitBucket, capacity, rate float64, at time.Time) (float64, time.Time) {
+	tokens := capacity
+	if elapsed := at.Sub(bucket.Updated_At).Seconds(); elapsed >= 0 {
+		tokens = math.Min(capacity, bucket.Tokens+elapsed*rate)
+	}
+	full := at.Add(time.Duration((capacity - tokens + 1) / rate * float64(time.Second)))
+	return tokens, full
+}
+
+type bucketRepository struct {
+	c Collection[models.RateLimitBucket]
+}
+
+// takeAttempts bounds the optimistic retries when concurrent requests race
+// to update the same bucket.
+const takeAttempts = 5
+
+func (r *bucketRepository) Take(ctx context.Context, key string, capacity, rate float64, at time.Time) (bool, float64, error) {
+	for attempt := 0; attempt < takeAttempts; attempt++ {
+		bucket, err := r.c.FindOne(ctx, bson.M{"key": key})
+		if errors.Is(err, ErrNotFound) {
+			bucket = &models.RateLimitBucket{Bucket_ID: primitive.NewObjectID(), Key: key, Tokens: capacity, Updated_At: at, Expires_At: at}
+			if err := r.c.InsertOne(ctx, bucket); err != nil {
+				// Another request created it first; the unique index on
+				// key rejected ours.
+				continue
+			}
+		} else if err != nil {
+			return false, 0, err
+		}
+
+		tokens, full := refill(bucket, capacity, rate, at)
+		if tokens < 1 {
+			return false, tokens, nil
+		}
+		tokens--
+
+		err = r.c.UpdateOne(ctx,
+			bson.M{"_id": bucket.Bucket_ID, "version": bucket.Version},
+			bson.M{"$set": bson.M{
+				"tokens":     tokens,
+				"version":    bucket.Version + 1,
+				"updated_at": at,
+				"expires_at": full,
+			}})
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return false, 0, err
+		}
+		return true, tokens, nil
+	}
+	return false, 0, fmt.Errorf("rate limit bucket %s is too contended", key)
+}
+
+// bucketSweepInterval is how often memory buckets that are full again are
+// dropped.
+const bucketSweepInterval = time.Minute
+
+// memoryBuckets keeps buckets in a map by key. A bucket that is full again
+// is no different from a missing one, so it is dropped by the next sweep.
+type memoryBuckets struct {
+	mu        sync.Mutex
+	buckets   map[string]*models.RateLimitBucket
+	lastSweep time.Time
+}
+
+func (m *memoryBuckets) Take(ctx context.Context, key string, capacity, rate float64, at time.Time) (bool, float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if at.Sub(m.lastSweep) >= bucketSweepInterval {
+		for k, bucket := range m.buckets {
+			if !bucket.Expires_At.After(at) {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = at
+	}
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &models.RateLimitBucket{Key: key, Tokens: capacity, Updated_At: at}
+		m.buckets[key] = bucket
+	}
+	tokens, full := refill(bucket, capacity, rate, at)
+	if tokens < 1 {
+		return false, tokens, nil
+	}
+	tokens--
+	bucket.Tokens, bucket.Updated_At, bucket.Expires_At = tokens, at, full
+	return true, tokens, nil
+}
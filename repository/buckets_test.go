@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryBucketsTake(t *testing.T) {
+	buckets := NewMemoryBuckets()
+	ctx := context.Background()
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	take := func(key string, at time.Time) bool {
+		t.Helper()
+		ok, _, err := buckets.Take(ctx, key, 2, 1, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if !take("a", start) || !take("a", start) {
+		t.Fatal("a full bucket of two refused a token")
+	}
+	if take("a", start) {
+		t.Error("an empty bucket gave a token")
+	}
+	if !take("b", start) {
+		t.Error("buckets are not separate by key")
+	}
+	if !take("a", start.Add(time.Second)) {
+		t.Error("the bucket did not refill after a second")
+	}
+	if take("a", start.Add(time.Second)) {
+		t.Error("the bucket refilled more than one token in a second")
+	}
+}
+
+func TestMemoryBucketsSweep(t *testing.T) {
+	buckets := NewMemoryBuckets().(*memoryBuckets)
+	ctx := context.Background()
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	buckets.Take(ctx, "slow", 1, 1.0/3600, start)
+	buckets.Take(ctx, "fast", 1, 1, start)
+	buckets.Take(ctx, "other", 1, 1, start.Add(bucketSweepInterval))
+
+	if _, ok := buckets.buckets["fast"]; ok {
+		t.Error("a bucket that was full again was not swept")
+	}
+	if _, ok := buckets.buckets["slow"]; !ok {
+		t.Error("a bucket that is still refilling was swept")
+	}
+	if ok, _, _ := buckets.Take(ctx, "slow", 1, 1.0/3600, start.Add(bucketSweepInterval)); ok {
+		t.Error("sweeping reset a bucket that is still refilling")
+	}
+}
@@ -0,0 +1,621 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"nanosoft/query"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryCollection keeps documents as bson.M in insertion order. Filters and
+// updates are evaluated with a small subset of MongoDB semantics: field
+// equality (including array membership), $eq, $ne, $gt, $gte, $lt, $lte,
+// $in, $nin, $exists, $and and $or; and the $set, $unset, $inc, $push,
+// $addToSet and $pull update operators.
+type memoryCollection[T any] struct {
+	mu   sync.RWMutex
+	docs []bson.M
+}
+
+func newMemoryCollection[T any]() *memoryCollection[T] {
+	return &memoryCollection[T]{}
+}
+
+// toM round-trips v through BSON so documents, filters and updates all use
+// the same driver types (primitive.DateTime, bson.A, bson.M and so on).
+func toM(v interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	if err := bson.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func fromM[T any](doc bson.M) (*T, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var out T
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (m *memoryCollection[T]) Find(ctx context.Context, filter bson.M, list query.List) (query.Page[T], error) {
+	page := query.Page[T]{Items: []T{}}
+
+	totalFilter, err := toM(list.Filter(filter, false))
+	if err != nil {
+		return page, err
+	}
+	pageFilter, err := toM(list.Filter(filter, true))
+	if err != nil {
+		return page, err
+	}
+
+	m.mu.RLock()
+	var matched []bson.M
+	for _, doc := range m.docs {
+		if matches(doc, totalFilter) {
+			page.Total++
+		}
+		if matches(doc, pageFilter) {
+			matched = append(matched, doc)
+		}
+	}
+	m.mu.RUnlock()
+
+	order := list.SortOrder()
+	sort.SliceStable(matched, func(i, j int) bool {
+		for _, s := range order {
+			a, _ := lookup(matched[i], s.Field)
+			b, _ := lookup(matched[j], s.Field)
+			if c := sortCompare(a, b); c != 0 {
+				return (c < 0) != s.Desc
+			}
+		}
+		return false
+	})
+
+	skip := list.Skip()
+	if skip > int64(len(matched)) {
+		skip = int64(len(matched))
+	}
+	matched = matched[skip:]
+	if list.Limit > 0 && int64(len(matched)) > list.Limit {
+		matched = matched[:list.Limit]
+	}
+
+	var last primitive.ObjectID
+	for _, doc := range matched {
+		item, err := fromM[T](doc)
+		if err != nil {
+			return page, err
+		}
+		if id, ok := doc["_id"].(primitive.ObjectID); ok {
+			last = id
+		}
+		page.Items = append(page.Items, *item)
+	}
+
+	page.NextCursor = list.NextCursor(len(page.Items), last)
+	return page, nil
+}
+
+func (m *memoryCollection[T]) FindOne(ctx context.Context, filter bson.M) (*T, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, doc := range m.docs {
+		if matches(doc, f) {
+			return fromM[T](doc)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryCollection[T]) Count(ctx context.Context, filter bson.M) (int64, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var count int64
+	for _, doc := range m.docs {
+		if matches(doc, f) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memoryCollection[T]) InsertOne(ctx context.Context, doc *T) error {
+	d, err := toM(doc)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id, ok := d["_id"]; ok {
+		for _, existing := range m.docs {
+			if equals(existing["_id"], id) {
+				return fmt.Errorf("duplicate key: _id %v", id)
+			}
+		}
+	} else {
+		d["_id"] = primitive.NewObjectID()
+	}
+	m.docs = append(m.docs, d)
+	return nil
+}
+
+func (m *memoryCollection[T]) UpdateOne(ctx context.Context, filter, update bson.M) error {
+	n, err := m.update(filter, update, false)
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (m *memoryCollection[T]) UpdateMany(ctx context.Context, filter, update bson.M) (int64, error) {
+	return m.update(filter, update, true)
+}
+
+func (m *memoryCollection[T]) update(filter, update bson.M, many bool) (int64, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return 0, err
+	}
+	u, err := toM(update)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for i, doc := range m.docs {
+		if !matches(doc, f) {
+			continue
+		}
+		updated, err := toM(doc)
+		if err != nil {
+			return n, err
+		}
+		if err := applyUpdate(updated, u); err != nil {
+			return n, err
+		}
+		m.docs[i] = updated
+		n++
+		if !many {
+			break
+		}
+	}
+	return n, nil
+}
+
+func (m *memoryCollection[T]) DeleteOne(ctx context.Context, filter bson.M) error {
+	n, err := m.delete(filter, false)
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (m *memoryCollection[T]) DeleteMany(ctx context.Context, filter bson.M) (int64, error) {
+	return m.delete(filter, true)
+}
+
+func (m *memoryCollection[T]) delete(filter bson.M, many bool) (int64, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	kept := m.docs[:0]
+	for _, doc := range m.docs {
+		if (many || n == 0) && matches(doc, f) {
+			n++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	m.docs = kept
+	return n, nil
+}
+
+func matches(doc, filter bson.M) bool {
+	for key, cond := range filter {
+		switch key {
+		case "$and":
+			for _, sub := range asArray(cond) {
+				if f, ok := sub.(bson.M); !ok || !matches(doc, f) {
+					return false
+				}
+			}
+		case "$or":
+			found := false
+			for _, sub := range asArray(cond) {
+				if f, ok := sub.(bson.M); ok && matches(doc, f) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		default:
+			value, exists := lookup(doc, key)
+			if !matchCondition(value, exists, cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchCondition(value interface{}, exists bool, cond interface{}) bool {
+	ops, ok := cond.(bson.M)
+	if !ok || !isOperatorDoc(ops) {
+		return equals(value, cond)
+	}
+	for op, arg := range ops {
+		switch op {
+		case "$eq":
+			if !equals(value, arg) {
+				return false
+			}
+		case "$ne":
+			if equals(value, arg) {
+				return false
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			if !anyElement(value, func(v interface{}) bool { return compareOp(v, op, arg) }) {
+				return false
+			}
+		case "$in":
+			if !inArray(value, arg) {
+				return false
+			}
+		case "$nin":
+			if inArray(value, arg) {
+				return false
+			}
+		case "$exists":
+			if want, _ := arg.(bool); want != exists {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isOperatorDoc(m bson.M) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+func asArray(v interface{}) bson.A {
+	a, _ := v.(bson.A)
+	return a
+}
+
+func inArray(value, arg interface{}) bool {
+	for _, candidate := range asArray(arg) {
+		if equals(value, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyElement applies fn to value, or to each element when value is an array.
+func anyElement(value interface{}, fn func(interface{}) bool) bool {
+	if arr, ok := value.(bson.A); ok {
+		for _, v := range arr {
+			if fn(v) {
+				return true
+			}
+		}
+		return false
+	}
+	return fn(value)
+}
+
+// equals compares like MongoDB equality: a scalar matches an array holding
+// it and a nil filter value matches a missing or null field.
+func equals(value, want interface{}) bool {
+	if want == nil {
+		return value == nil
+	}
+	if arr, ok := value.(bson.A); ok {
+		if _, wantArr := want.(bson.A); !wantArr {
+			for _, v := range arr {
+				if equals(v, want) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if c, ok := compare(value, want); ok {
+		return c == 0
+	}
+	return reflect.DeepEqual(value, want)
+}
+
+func compareOp(value interface{}, op string, arg interface{}) bool {
+	c, ok := compare(value, arg)
+	if !ok {
+		return false
+	}
+	switch op {
+	case "$gt":
+		return c > 0
+	case "$gte":
+		return c >= 0
+	case "$lt":
+		return c < 0
+	}
+	return c <= 0
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// compare orders two scalars of the same BSON type.
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(x[:], y[:]), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case !x:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// sortRank follows MongoDB's cross-type sort order.
+func sortRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int32, int64, float64:
+		return 1
+	case string:
+		return 2
+	case bson.M:
+		return 3
+	case bson.A:
+		return 4
+	case primitive.ObjectID:
+		return 5
+	case bool:
+		return 6
+	case primitive.DateTime:
+		return 7
+	}
+	return 8
+}
+
+func sortCompare(a, b interface{}) int {
+	ra, rb := sortRank(a), sortRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	c, _ := compare(a, b)
+	return c
+}
+
+func lookup(doc bson.M, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case bson.M:
+			v, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case bson.A:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			current = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func setPath(doc bson.M, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	node := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := node[part].(bson.M)
+		if !ok {
+			if node[part] != nil {
+				return fmt.Errorf("cannot set %s: %s is not a document", path, part)
+			}
+			next = bson.M{}
+			node[part] = next
+		}
+		node = next
+	}
+	node[parts[len(parts)-1]] = value
+	return nil
+}
+
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	node := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := node[part].(bson.M)
+		if !ok {
+			return
+		}
+		node = next
+	}
+	delete(node, parts[len(parts)-1])
+}
+
+func add(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	}
+	switch x := a.(type) {
+	case int32:
+		if y, ok := b.(int32); ok {
+			return x + y, nil
+		}
+	case int64:
+		switch y := b.(type) {
+		case int32:
+			return x + int64(y), nil
+		case int64:
+			return x + y, nil
+		}
+	}
+	x, ok := number(a)
+	y, ok2 := number(b)
+	if !ok || !ok2 {
+		return nil, errors.New("$inc applied to a non-numeric value")
+	}
+	return x + y, nil
+}
+
+func applyUpdate(doc, update bson.M) error {
+	for op, arg := range update {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return fmt.Errorf("%s expects a document", op)
+		}
+		for path, value := range fields {
+			current, _ := lookup(doc, path)
+			var err error
+			switch op {
+			case "$set":
+				err = setPath(doc, path, value)
+			case "$unset":
+				unsetPath(doc, path)
+			case "$inc":
+				var sum interface{}
+				if sum, err = add(current, value); err == nil {
+					err = setPath(doc, path, sum)
+				}
+			case "$push", "$addToSet":
+				arr := asArray(current)
+				items := bson.A{value}
+				if each, ok := value.(bson.M); ok && each["$each"] != nil {
+					items = asArray(each["$each"])
+				}
+				for _, item := range items {
+					if op == "$addToSet" && inArray(item, arr) {
+						continue
+					}
+					arr = append(arr, item)
+				}
+				err = setPath(doc, path, arr)
+			case "$pull":
+				kept := bson.A{}
+				for _, item := range asArray(current) {
+					cond, isDoc := value.(bson.M)
+					if equals(item, value) || (isDoc && !isOperatorDoc(cond) && matchesItem(item, cond)) ||
+						(isDoc && isOperatorDoc(cond) && matchCondition(item, true, cond)) {
+						continue
+					}
+					kept = append(kept, item)
+				}
+				err = setPath(doc, path, kept)
+			default:
+				return fmt.Errorf("unsupported update operator %s", op)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func matchesItem(item interface{}, cond bson.M) bool {
+	doc, ok := item.(bson.M)
+	return ok && matches(doc, cond)
+}
@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bsonDoc converts v the way the memory collection converts documents,
+// filters and updates before using them.
+func bsonDoc(t *testing.T, v bson.M) bson.M {
+	t.Helper()
+	m, err := toM(v)
+	if err != nil {
+		t.Fatalf("toM(%v): %v", v, err)
+	}
+	return m
+}
+
+func TestMatches(t *testing.T) {
+	id := primitive.NewObjectID()
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	doc := bson.M{
+		"_id":     id,
+		"title":   "Hello",
+		"count":   int32(3),
+		"big":     int64(10),
+		"ratio":   0.5,
+		"tags":    bson.A{"go", "mongo"},
+		"author":  bson.M{"name": "Ann", "role": int32(1)},
+		"items":   bson.A{bson.M{"n": int32(1)}, bson.M{"n": int32(5)}},
+		"created": now,
+		"deleted": nil,
+		"active":  true,
+	}
+
+	tests := []struct {
+		name   string
+		filter bson.M
+		want   bool
+	}{
+		{"empty filter", bson.M{}, true},
+		{"equal string", bson.M{"title": "Hello"}, true},
+		{"different string", bson.M{"title": "hello"}, false},
+		{"object id", bson.M{"_id": id}, true},
+		{"int32 against int64", bson.M{"count": int64(3)}, true},
+		{"int against float", bson.M{"big": 10.0}, true},
+		{"scalar in array", bson.M{"tags": "go"}, true},
+		{"scalar not in array", bson.M{"tags": "rust"}, false},
+		{"whole array", bson.M{"tags": bson.A{"go", "mongo"}}, true},
+		{"array in other order", bson.M{"tags": bson.A{"mongo", "go"}}, false},
+		{"dotted path", bson.M{"author.name": "Ann"}, true},
+		{"array index path", bson.M{"items.1.n": int32(5)}, true},
+		{"index out of range", bson.M{"items.2.n": nil}, true},
+		{"nil matches null", bson.M{"deleted": nil}, true},
+		{"nil matches missing", bson.M{"missing": nil}, true},
+		{"nil does not match value", bson.M{"title": nil}, false},
+		{"$eq", bson.M{"count": bson.M{"$eq": int32(3)}}, true},
+		{"$ne", bson.M{"count": bson.M{"$ne": int32(3)}}, false},
+		{"$ne nil", bson.M{"deleted": bson.M{"$ne": nil}}, false},
+		{"$gt", bson.M{"count": bson.M{"$gt": int32(2)}}, true},
+		{"$gte equal", bson.M{"count": bson.M{"$gte": int32(3)}}, true},
+		{"$lt", bson.M{"count": bson.M{"$lt": int32(3)}}, false},
+		{"$lte", bson.M{"ratio": bson.M{"$lte": 0.5}}, true},
+		{"range", bson.M{"count": bson.M{"$gt": int32(1), "$lt": int32(3)}}, false},
+		{"$gt on string", bson.M{"title": bson.M{"$gt": "A"}}, true},
+		{"$gt across types", bson.M{"title": bson.M{"$gt": int32(1)}}, false},
+		{"$lt on time", bson.M{"created": bson.M{"$lt": now.Add(time.Second)}}, true},
+		{"$gte on time", bson.M{"created": bson.M{"$gte": now.Add(time.Second)}}, false},
+		{"$in", bson.M{"title": bson.M{"$in": bson.A{"Hi", "Hello"}}}, true},
+		{"$in array field", bson.M{"tags": bson.M{"$in": bson.A{"rust", "mongo"}}}, true},
+		{"$nin", bson.M{"title": bson.M{"$nin": bson.A{"Hello"}}}, false},
+		{"$exists", bson.M{"title": bson.M{"$exists": true}}, true},
+		{"$exists on null", bson.M{"deleted": bson.M{"$exists": true}}, true},
+		{"$exists false", bson.M{"missing": bson.M{"$exists": false}}, true},
+		{"unknown operator", bson.M{"title": bson.M{"$regex": "H"}}, false},
+		{"sub-document equality", bson.M{"author": bson.M{"name": "Ann", "role": int32(1)}}, true},
+		{"bool", bson.M{"active": true}, true},
+		{"$and", bson.M{"$and": bson.A{bson.M{"title": "Hello"}, bson.M{"count": int32(3)}}}, true},
+		{"$and failing", bson.M{"$and": bson.A{bson.M{"title": "Hello"}, bson.M{"count": int32(4)}}}, false},
+		{"$or", bson.M{"$or": bson.A{bson.M{"title": "Bye"}, bson.M{"count": int32(3)}}}, true},
+		{"$or failing", bson.M{"$or": bson.A{bson.M{"title": "Bye"}, bson.M{"count": int32(4)}}}, false},
+		{"all conditions apply", bson.M{"title": "Hello", "count": int32(4)}, false},
+	}
+	converted := bsonDoc(t, doc)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matches(converted, bsonDoc(t, tt.filter)); got != tt.want {
+				t.Errorf("matches(%v) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     bson.M
+		update  bson.M
+		want    bson.M
+		wantErr bool
+	}{
+		{
+			name:   "$set replaces and adds fields",
+			doc:    bson.M{"a": int32(1)},
+			update: bson.M{"$set": bson.M{"a": int32(2), "b": "x"}},
+			want:   bson.M{"a": int32(2), "b": "x"},
+		},
+		{
+			name:   "$set creates sub-documents",
+			doc:    bson.M{},
+			update: bson.M{"$set": bson.M{"a.b.c": int32(1)}},
+			want:   bson.M{"a": bson.M{"b": bson.M{"c": int32(1)}}},
+		},
+		{
+			name:    "$set through a scalar",
+			doc:     bson.M{"a": "x"},
+			update:  bson.M{"$set": bson.M{"a.b": int32(1)}},
+			wantErr: true,
+		},
+		{
+			name:   "$unset removes fields",
+			doc:    bson.M{"a": int32(1), "b": bson.M{"c": int32(2), "d": int32(3)}},
+			update: bson.M{"$unset": bson.M{"a": "", "b.c": "", "missing.x": ""}},
+			want:   bson.M{"b": bson.M{"d": int32(3)}},
+		},
+		{
+			name:   "$inc adds to int32",
+			doc:    bson.M{"n": int32(1)},
+			update: bson.M{"$inc": bson.M{"n": int32(2)}},
+			want:   bson.M{"n": int32(3)},
+		},
+		{
+			name:   "$inc widens to int64",
+			doc:    bson.M{"n": int64(1)},
+			update: bson.M{"$inc": bson.M{"n": int32(2)}},
+			want:   bson.M{"n": int64(3)},
+		},
+		{
+			name:   "$inc of a missing field",
+			doc:    bson.M{},
+			update: bson.M{"$inc": bson.M{"n": int64(5)}},
+			want:   bson.M{"n": int64(5)},
+		},
+		{
+			name:   "$inc of a float",
+			doc:    bson.M{"n": 1.5},
+			update: bson.M{"$inc": bson.M{"n": int32(1)}},
+			want:   bson.M{"n": 2.5},
+		},
+		{
+			name:    "$inc of a string",
+			doc:     bson.M{"n": "1"},
+			update:  bson.M{"$inc": bson.M{"n": int32(1)}},
+			wantErr: true,
+		},
+		{
+			name:   "$push appends",
+			doc:    bson.M{"tags": bson.A{"a"}},
+			update: bson.M{"$push": bson.M{"tags": "a"}},
+			want:   bson.M{"tags": bson.A{"a", "a"}},
+		},
+		{
+			name:   "$push creates the array",
+			doc:    bson.M{},
+			update: bson.M{"$push": bson.M{"tags": "a"}},
+			want:   bson.M{"tags": bson.A{"a"}},
+		},
+		{
+			name:   "$push $each",
+			doc:    bson.M{"tags": bson.A{"a"}},
+			update: bson.M{"$push": bson.M{"tags": bson.M{"$each": bson.A{"b", "c"}}}},
+			want:   bson.M{"tags": bson.A{"a", "b", "c"}},
+		},
+		{
+			name:   "$addToSet skips present values",
+			doc:    bson.M{"tags": bson.A{"a"}},
+			update: bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": bson.A{"a", "b", "b"}}}},
+			want:   bson.M{"tags": bson.A{"a", "b"}},
+		},
+		{
+			name:   "$pull by value",
+			doc:    bson.M{"tags": bson.A{"a", "b", "a"}},
+			update: bson.M{"$pull": bson.M{"tags": "a"}},
+			want:   bson.M{"tags": bson.A{"b"}},
+		},
+		{
+			name:   "$pull by sub-document fields",
+			doc:    bson.M{"notes": bson.A{bson.M{"id": "1", "text": "x"}, bson.M{"id": "2", "text": "y"}}},
+			update: bson.M{"$pull": bson.M{"notes": bson.M{"id": "1"}}},
+			want:   bson.M{"notes": bson.A{bson.M{"id": "2", "text": "y"}}},
+		},
+		{
+			name:   "$pull by condition",
+			doc:    bson.M{"n": bson.A{int32(1), int32(5), int32(9)}},
+			update: bson.M{"$pull": bson.M{"n": bson.M{"$gte": int32(5)}}},
+			want:   bson.M{"n": bson.A{int32(1)}},
+		},
+		{
+			name:   "several operators",
+			doc:    bson.M{"a": int32(1), "b": int32(1)},
+			update: bson.M{"$set": bson.M{"a": int32(2)}, "$inc": bson.M{"b": int32(1)}},
+			want:   bson.M{"a": int32(2), "b": int32(2)},
+		},
+		{
+			name:    "unknown operator",
+			doc:     bson.M{},
+			update:  bson.M{"$rename": bson.M{"a": "b"}},
+			wantErr: true,
+		},
+		{
+			name:    "operator without a document",
+			doc:     bson.M{},
+			update:  bson.M{"$set": "a"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := bsonDoc(t, tt.doc)
+			update := tt.update
+			if !tt.wantErr {
+				update = bsonDoc(t, update)
+			}
+			err := applyUpdate(doc, update)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("applyUpdate(%v) succeeded, want an error", tt.update)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyUpdate(%v): %v", tt.update, err)
+			}
+			if want := bsonDoc(t, tt.want); !reflect.DeepEqual(doc, want) {
+				t.Errorf("applyUpdate(%v) = %v, want %v", tt.update, doc, want)
+			}
+		})
+	}
+}
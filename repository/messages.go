@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"nanosoft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MessageRepository interface {
+	Repository[models.Message]
+	TrashRepository[models.Message]
+	// MarkRead moves a new message to read, and leaves any other alone.
+	MarkRead(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	AddNote(ctx context.Context, id primitive.ObjectID, note models.Note) error
+	RemoveNote(ctx context.Context, id, noteID primitive.ObjectID) error
+	// AddReply appends reply to the thread and marks the message replied.
+	AddReply(ctx context.Context, id primitive.ObjectID, reply models.Reply) error
+}
+
+type messageRepository struct {
+	crud[models.Message]
+	trash[models.Message]
+}
+
+func newMessageRepository(c Collection[models.Message]) *messageRepository {
+	return &messageRepository{crud[models.Message]{liveCollection[models.Message]{c}}, trash[models.Message]{all: c}}
+}
+
+// MarkRead also matches messages stored before messages had a status.
+func (r *messageRepository) MarkRead(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	filter := bson.M{"_id": id, "status": bson.M{"$in": bson.A{models.MessageNew, "", nil}}}
+	return r.c.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": models.MessageRead, "updated_at": at}})
+}
+
+func (r *messageRepository) AddNote(ctx context.Context, id primitive.ObjectID, note models.Note) error {
+	return r.c.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$push": bson.M{"notes": note},
+		"$set":  bson.M{"updated_at": note.Created_At},
+	})
+}
+
+func (r *messageRepository) RemoveNote(ctx context.Context, id, noteID primitive.ObjectID) error {
+	return r.c.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$pull": bson.M{"notes": bson.M{"_id": noteID}},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+}
+
+func (r *messageRepository) AddReply(ctx context.Context, id primitive.ObjectID, reply models.Reply) error {
+	return r.c.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$push": bson.M{"thread": reply},
+		"$set":  bson.M{"status": models.MessageReplied, "updated_at": reply.Created_At},
+	})
+}
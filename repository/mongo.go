@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"nanosoft/query"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var mongoIndexes = map[string][]mongo.IndexModel{
+	"Sessions": {
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "family_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"RevokedTokens": {
+		{Keys: bson.D{{Key: "token_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"LoginAttempts": {
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"Lockouts": {
+		{Keys: bson.D{{Key: "key", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"RateLimits": {
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"Users": {
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+	},
+	"Services": {
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+	},
+	"Projects": {
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+	},
+	"Remarks": {
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+	},
+	"Emails": {
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+		{Keys: bson.D{{Key: "assignee_id", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "content_hash", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"Outbox": {
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "message_id", Value: 1}}},
+	},
+	"EmailTemplates": {
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "locale", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"AuditLog": {
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "resource", Value: 1}, {Key: "resource_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"PasswordResets": {
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+}
+
+type mongoCollection[T any] struct {
+	coll *mongo.Collection
+}
+
+func newMongoCollection[T any](db *mongo.Database, name string) *mongoCollection[T] {
+	return &mongoCollection[T]{coll: db.Collection(name)}
+}
+
+func (m *mongoCollection[T]) Find(ctx context.Context, filter bson.M, list query.List) (query.Page[T], error) {
+	page := query.Page[T]{Items: []T{}}
+
+	total, err := m.coll.CountDocuments(ctx, list.Filter(filter, false))
+	if err != nil {
+		return page, err
+	}
+	page.Total = total
+
+	cursor, err := m.coll.Find(ctx, list.Filter(filter, true), list.FindOptions())
+	if err != nil {
+		return page, err
+	}
+	defer cursor.Close(ctx)
+
+	var last primitive.ObjectID
+	for cursor.Next(ctx) {
+		var item T
+		if err := cursor.Decode(&item); err != nil {
+			return page, err
+		}
+		if id, ok := cursor.Current.Lookup("_id").ObjectIDOK(); ok {
+			last = id
+		}
+		page.Items = append(page.Items, item)
+	}
+	if err := cursor.Err(); err != nil {
+		return page, err
+	}
+
+	page.NextCursor = list.NextCursor(len(page.Items), last)
+	return page, nil
+}
+
+func (m *mongoCollection[T]) FindOne(ctx context.Context, filter bson.M) (*T, error) {
+	var doc T
+	err := m.coll.FindOne(ctx, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (m *mongoCollection[T]) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return m.coll.CountDocuments(ctx, filter)
+}
+
+func (m *mongoCollection[T]) InsertOne(ctx context.Context, doc *T) error {
+	_, err := m.coll.InsertOne(ctx, doc)
+	return err
+}
+
+func (m *mongoCollection[T]) UpdateOne(ctx context.Context, filter, update bson.M) error {
+	result, err := m.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *mongoCollection[T]) UpdateMany(ctx context.Context, filter, update bson.M) (int64, error) {
+	result, err := m.coll.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.MatchedCount, nil
+}
+
+func (m *mongoCollection[T]) DeleteOne(ctx context.Context, filter bson.M) error {
+	result, err := m.coll.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *mongoCollection[T]) DeleteMany(ctx context.Context, filter bson.M) (int64, error) {
+	result, err := m.coll.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
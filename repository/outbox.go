@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"nanosoft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OutboxRepository interface {
+	Repository[models.OutboundEmail]
+	// Claim leases an email that is due for delivery to the caller until
+	// at+lease, or returns ErrNotFound if none is due. Emails whose lease
+	// ran out, because the worker sending them died, are due again.
+	Claim(ctx context.Context, at time.Time, lease time.Duration) (*models.OutboundEmail, error)
+	// Requeue makes a failed email due again. It returns ErrNotFound if
+	// there is no failed email with id.
+	Requeue(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+type outboxRepository struct {
+	crud[models.OutboundEmail]
+}
+
+// claimAttempts bounds how often Claim looks for another email when
+// concurrent workers keep taking the one it found.
+const claimAttempts = 5
+
+func (r *outboxRepository) Claim(ctx context.Context, at time.Time, lease time.Duration) (*models.OutboundEmail, error) {
+	due := bson.M{"$or": []bson.M{
+		{"status": models.OutboxPending, "next_attempt_at": bson.M{"$lte": at}},
+		{"status": models.OutboxSending, "locked_until": bson.M{"$lte": at}},
+	}}
+	for attempt := 0; attempt < claimAttempts; attempt++ {
+		email, err := r.c.FindOne(ctx, due)
+		if err != nil {
+			return nil, err
+		}
+		until := at.Add(lease)
+		err = r.c.UpdateOne(ctx,
+			bson.M{"_id": email.Outbound_ID, "status": email.Status, "locked_until": email.Locked_Until},
+			bson.M{"$set": bson.M{"status": models.OutboxSending, "locked_until": until, "updated_at": at}})
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		email.Status = models.OutboxSending
+		email.Locked_Until = &until
+		return email, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *outboxRepository) Requeue(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.c.UpdateOne(ctx,
+		bson.M{"_id": id, "status": models.OutboxFailed},
+		bson.M{"$set": bson.M{
+			"status":          models.OutboxPending,
+			"attempts":        0,
+			"next_attempt_at": at,
+			"locked_until":    nil,
+			"updated_at":      at,
+		}})
+}
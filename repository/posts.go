@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"context"
+
+	"nanosoft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PostRepository interface {
+	Repository[models.Post]
+	FindBySlug(ctx context.Context, slug string, filter bson.M) (*models.Post, error)
+	SlugTaken(ctx context.Context, slug string, exclude primitive.ObjectID) (bool, error)
+}
+
+type postRepository struct {
+	crud[models.Post]
+}
+
+// FindBySlug returns the post with slug that also matches filter.
+func (r *postRepository) FindBySlug(ctx context.Context, slug string, filter bson.M) (*models.Post, error) {
+	return r.c.FindOne(ctx, bson.M{"$and": []bson.M{{"slug": slug}, filter}})
+}
+
+func (r *postRepository) SlugTaken(ctx context.Context, slug string, exclude primitive.ObjectID) (bool, error) {
+	count, err := r.c.Count(ctx, bson.M{"slug": slug, "_id": bson.M{"$ne": exclude}})
+	return count > 0, err
+}
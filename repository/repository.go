@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"nanosoft/models"
+	"nanosoft/query"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrNotFound is returned when no document matches a lookup, update or delete.
+var ErrNotFound = errors.New("document not found")
+
+// ErrConflict is returned when a document is no longer at the version a
+// conditional update or delete expected.
+var ErrConflict = errors.New("document has been modified")
+
+// Collection is the minimal document store the repositories are built on.
+// Filters and updates use MongoDB syntax; the in-memory implementation
+// understands the subset of operators the repositories rely on.
+type Collection[T any] interface {
+	Find(ctx context.Context, filter bson.M, list query.List) (query.Page[T], error)
+	FindOne(ctx context.Context, filter bson.M) (*T, error)
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	InsertOne(ctx context.Context, doc *T) error
+	UpdateOne(ctx context.Context, filter, update bson.M) error
+	UpdateMany(ctx context.Context, filter, update bson.M) (int64, error)
+	DeleteOne(ctx context.Context, filter bson.M) error
+	DeleteMany(ctx context.Context, filter bson.M) (int64, error)
+}
+
+// Repository is the storage contract shared by every model.
+type Repository[T any] interface {
+	List(ctx context.Context, filter bson.M, list query.List) (query.Page[T], error)
+	Get(ctx context.Context, id primitive.ObjectID) (*T, error)
+	FindOne(ctx context.Context, filter bson.M) (*T, error)
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	Create(ctx context.Context, doc *T) error
+	Update(ctx context.Context, id primitive.ObjectID, set bson.M) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// VersionedRepository stores documents with a version field, which every
+// update increments, and soft deletes them. UpdateIf and TrashIf only act
+// on a document that is still at the given version, and return ErrConflict
+// otherwise. Documents stored before they were versioned count as version 0.
+type VersionedRepository[T any] interface {
+	Repository[T]
+	TrashRepository[T]
+	UpdateIf(ctx context.Context, id primitive.ObjectID, version int64, set bson.M) error
+	TrashIf(ctx context.Context, id primitive.ObjectID, version int64, by string) error
+}
+
+type (
+	ServiceRepository = VersionedRepository[models.Service]
+	ProjectRepository = VersionedRepository[models.Project]
+	RemarkRepository  = VersionedRepository[models.Remark]
+)
+
+// Store bundles every repository the API needs.
+type Store struct {
+	Users       UserRepository
+	Services    ServiceRepository
+	Projects    ProjectRepository
+	Remarks     RemarkRepository
+	Messages    MessageRepository
+	Posts       PostRepository
+	Sessions    SessionRepository
+	Revocations RevocationRepository
+	Resets      PasswordResetRepository
+	Attempts    LoginAttemptRepository
+	Lockouts    LockoutRepository
+	Buckets     BucketRepository
+	Outbox      OutboxRepository
+	Templates   TemplateRepository
+	Audit       AuditRepository
+
+	db *mongo.Database
+}
+
+// NewMongoStore returns repositories backed by collections in db.
+func NewMongoStore(db *mongo.Database) *Store {
+	return &Store{
+		Users:       &userRepository{*newVersioned[models.User](newMongoCollection[models.User](db, "Users"))},
+		Services:    newVersioned[models.Service](newMongoCollection[models.Service](db, "Services")),
+		Projects:    newVersioned[models.Project](newMongoCollection[models.Project](db, "Projects")),
+		Remarks:     newVersioned[models.Remark](newMongoCollection[models.Remark](db, "Remarks")),
+		Messages:    newMessageRepository(newMongoCollection[models.Message](db, "Emails")),
+		Posts:       &postRepository{crud[models.Post]{newMongoCollection[models.Post](db, "Blogs")}},
+		Sessions:    &sessionRepository{newMongoCollection[models.Session](db, "Sessions")},
+		Revocations: &revocationRepository{newMongoCollection[models.RevokedToken](db, "RevokedTokens")},
+		Resets:      &passwordResetRepository{newMongoCollection[models.PasswordReset](db, "PasswordResets")},
+		Attempts:    &loginAttemptRepository{newMongoCollection[models.LoginAttempt](db, "LoginAttempts")},
+		Lockouts:    &lockoutRepository{crud[models.Lockout]{newMongoCollection[models.Lockout](db, "Lockouts")}},
+		Buckets:     &bucketRepository{newMongoCollection[models.RateLimitBucket](db, "RateLimits")},
+		Outbox:      &outboxRepository{crud[models.OutboundEmail]{newMongoCollection[models.OutboundEmail](db, "Outbox")}},
+		Templates:   &templateRepository{crud[models.EmailTemplate]{newMongoCollection[models.EmailTemplate](db, "EmailTemplates")}},
+		Audit:       &auditRepository{newMongoCollection[models.AuditEntry](db, "AuditLog")},
+		db:          db,
+	}
+}
+
+// NewMemoryStore returns thread-safe in-memory repositories, so the API can
+// run without a MongoDB server.
+func NewMemoryStore() *Store {
+	return &Store{
+		Users:       &userRepository{*newVersioned[models.User](newMemoryCollection[models.User]())},
+		Services:    newVersioned[models.Service](newMemoryCollection[models.Service]()),
+		Projects:    newVersioned[models.Project](newMemoryCollection[models.Project]()),
+		Remarks:     newVersioned[models.Remark](newMemoryCollection[models.Remark]()),
+		Messages:    newMessageRepository(newMemoryCollection[models.Message]()),
+		Posts:       &postRepository{crud[models.Post]{newMemoryCollection[models.Post]()}},
+		Sessions:    &sessionRepository{newMemoryCollection[models.Session]()},
+		Revocations: &revocationRepository{newMemoryCollection[models.RevokedToken]()},
+		Resets:      &passwordResetRepository{newMemoryCollection[models.PasswordReset]()},
+		Attempts:    &loginAttemptRepository{newMemoryCollection[models.LoginAttempt]()},
+		Lockouts:    &lockoutRepository{crud[models.Lockout]{newMemoryCollection[models.Lockout]()}},
+		Buckets:     NewMemoryBuckets(),
+		Outbox:      &outboxRepository{crud[models.OutboundEmail]{newMemoryCollection[models.OutboundEmail]()}},
+		Templates:   &templateRepository{crud[models.EmailTemplate]{newMemoryCollection[models.EmailTemplate]()}},
+		Audit:       &auditRepository{newMemoryCollection[models.AuditEntry]()},
+	}
+}
+
+// EnsureIndexes creates the indexes the repositories rely on, including
+// the TTL indexes that let MongoDB expire old documents. It does nothing for
+// the in-memory store, which filters expired documents on read instead.
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	if s.db == nil {
+		return nil
+	}
+	for collection, indexes := range mongoIndexes {
+		if _, err := s.db.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+			return fmt.Errorf("creating indexes on %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// crud implements Repository on top of any Collection.
+type crud[T any] struct {
+	c Collection[T]
+}
+
+func (r *crud[T]) List(ctx context.Context, filter bson.M, list query.List) (query.Page[T], error) {
+	return r.c.Find(ctx, filter, list)
+}
+
+func (r *crud[T]) Get(ctx context.Context, id primitive.ObjectID) (*T, error) {
+	return r.c.FindOne(ctx, bson.M{"_id": id})
+}
+
+func (r *crud[T]) FindOne(ctx context.Context, filter bson.M) (*T, error) {
+	return r.c.FindOne(ctx, filter)
+}
+
+func (r *crud[T]) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return r.c.Count(ctx, filter)
+}
+
+func (r *crud[T]) Create(ctx context.Context, doc *T) error {
+	return r.c.InsertOne(ctx, doc)
+}
+
+func (r *crud[T]) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	return r.c.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+}
+
+func (r *crud[T]) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return r.c.DeleteOne(ctx, bson.M{"_id": id})
+}
+
+// versioned implements VersionedRepository on top of any Collection.
+type versioned[T any] struct {
+	crud[T]
+	trash[T]
+}
+
+func newVersioned[T any](c Collection[T]) *versioned[T] {
+	return &versioned[T]{crud[T]{liveCollection[T]{c}}, trash[T]{all: c, versioned: true}}
+}
+
+func (r *versioned[T]) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	return r.c.UpdateOne(ctx, bson.M{"_id": id}, versionedUpdate(set))
+}
+
+func (r *versioned[T]) UpdateIf(ctx context.Context, id primitive.ObjectID, version int64, set bson.M) error {
+	err := r.c.UpdateOne(ctx, versionFilter(id, version), versionedUpdate(set))
+	return r.conflict(ctx, id, err)
+}
+
+func (r *versioned[T]) TrashIf(ctx context.Context, id primitive.ObjectID, version int64, by string) error {
+	filter := versionFilter(id, version)
+	filter["deleted_at"] = nil
+	err := r.trash.trash(ctx, filter, by)
+	return r.conflict(ctx, id, err)
+}
+
+// conflict turns ErrNotFound from a conditional write into ErrConflict when
+// the document exists, at another version and not in the trash.
+func (r *versioned[T]) conflict(ctx context.Context, id primitive.ObjectID, err error) error {
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if n, countErr := r.c.Count(ctx, bson.M{"_id": id}); countErr == nil && n > 0 {
+		return ErrConflict
+	}
+	return err
+}
+
+func versionedUpdate(set bson.M) bson.M {
+	return bson.M{"$set": set, "$inc": bson.M{"version": int64(1)}}
+}
+
+func versionFilter(id primitive.ObjectID, version int64) bson.M {
+	if version == 0 {
+		return bson.M{"_id": id, "version": bson.M{"$in": bson.A{int64(0), nil}}}
+	}
+	return bson.M{"_id": id, "version": version}
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"nanosoft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type PasswordResetRepository interface {
+	Create(ctx context.Context, reset *models.PasswordReset) error
+	// Consume marks the unexpired, unused reset for a token hash as used and
+	// returns it. It returns ErrNotFound if there is none, so a link works
+	// only once even when submitted twice at the same time.
+	Consume(ctx context.Context, hash string, at time.Time) (*models.PasswordReset, error)
+	// InvalidateUser marks every outstanding reset of a user as used.
+	InvalidateUser(ctx context.Context, userID string, at time.Time) error
+}
+
+type passwordResetRepository struct {
+	c Collection[models.PasswordReset]
+}
+
+func (r *passwordResetRepository) Create(ctx context.Context, reset *models.PasswordReset) error {
+	return r.c.InsertOne(ctx, reset)
+}
+
+func (r *passwordResetRepository) Consume(ctx context.Context, hash string, at time.Time) (*models.PasswordReset, error) {
+	err := r.c.UpdateOne(ctx,
+		bson.M{"token_hash": hash, "used_at": nil, "expires_at": bson.M{"$gt": at}},
+		bson.M{"$set": bson.M{"used_at": at}})
+	if err != nil {
+		return nil, err
+	}
+	return r.c.FindOne(ctx, bson.M{"token_hash": hash})
+}
+
+func (r *passwordResetRepository) InvalidateUser(ctx context.Context, userID string, at time.Time) error {
+	_, err := r.c.UpdateMany(ctx,
+		bson.M{"user_id": userID, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": at}})
+	return err
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"nanosoft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RevocationRepository interface {
+	// Revoke denylists tokenID until expiresAt.
+	Revoke(ctx context.Context, tokenID, reason string, expiresAt time.Time) error
+	// IsRevoked reports whether any of the non-empty ids is denylisted.
+	IsRevoked(ctx context.Context, ids ...string) (bool, error)
+}
+
+type revocationRepository struct {
+	c Collection[models.RevokedToken]
+}
+
+func (r *revocationRepository) Revoke(ctx context.Context, tokenID, reason string, expiresAt time.Time) error {
+	return r.c.InsertOne(ctx, &models.RevokedToken{
+		Revoked_ID: primitive.NewObjectID(),
+		Token_ID:   tokenID,
+		Reason:     reason,
+		Expires_At: expiresAt,
+		Created_At: time.Now(),
+	})
+}
+
+func (r *revocationRepository) IsRevoked(ctx context.Context, ids ...string) (bool, error) {
+	var candidates []string
+	for _, id := range ids {
+		if id != "" {
+			candidates = append(candidates, id)
+		}
+	}
+	if len(candidates) == 0 {
+		return false, nil
+	}
+	count, err := r.c.Count(ctx, bson.M{
+		"token_id":   bson.M{"$in": candidates},
+		"expires_at": bson.M{"$gt": time.Now()},
+	})
+	return count > 0, err
+}
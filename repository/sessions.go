@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"nanosoft/models"
+	"nanosoft/query"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *models.Session) error
+	// FindByHash returns the unexpired session for a refresh token hash,
+	// whether or not it has been rotated or revoked.
+	FindByHash(ctx context.Context, hash string) (*models.Session, error)
+	// MarkRotated records that a session's token was exchanged. It returns
+	// ErrNotFound if the session was already rotated or revoked, so of two
+	// concurrent refreshes with the same token only one succeeds.
+	MarkRotated(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	RevokeFamily(ctx context.Context, familyID string, at time.Time) error
+	// RevokeUser revokes every active session of a user and returns the
+	// families that were active.
+	RevokeUser(ctx context.Context, userID string, at time.Time) ([]string, error)
+}
+
+type sessionRepository struct {
+	c Collection[models.Session]
+}
+
+func (r *sessionRepository) Create(ctx context.Context, session *models.Session) error {
+	return r.c.InsertOne(ctx, session)
+}
+
+func (r *sessionRepository) FindByHash(ctx context.Context, hash string) (*models.Session, error) {
+	return r.c.FindOne(ctx, bson.M{"token_hash": hash, "expires_at": bson.M{"$gt": time.Now()}})
+}
+
+func (r *sessionRepository) MarkRotated(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.c.UpdateOne(ctx,
+		bson.M{"_id": id, "rotated_at": nil, "revoked_at": nil},
+		bson.M{"$set": bson.M{"rotated_at": at}})
+}
+
+func (r *sessionRepository) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	_, err := r.c.UpdateMany(ctx,
+		bson.M{"family_id": familyID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": at}})
+	return err
+}
+
+func (r *sessionRepository) RevokeUser(ctx context.Context, userID string, at time.Time) ([]string, error) {
+	filter := bson.M{"user_id": userID, "revoked_at": nil, "expires_at": bson.M{"$gt": at}}
+	active, err := r.c.Find(ctx, filter, query.List{})
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var families []string
+	for _, session := range active.Items {
+		if !seen[session.Family_ID] {
+			seen[session.Family_ID] = true
+			families = append(families, session.Family_ID)
+		}
+	}
+	_, err = r.c.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": at}})
+	return families, err
+}
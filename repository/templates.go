@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+
+	"nanosoft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type TemplateRepository interface {
+	Repository[models.EmailTemplate]
+	FindByName(ctx context.Context, name, locale string) (*models.EmailTemplate, error)
+	DeleteByName(ctx context.Context, name, locale string) error
+}
+
+type templateRepository struct {
+	crud[models.EmailTemplate]
+}
+
+func (r *templateRepository) FindByName(ctx context.Context, name, locale string) (*models.EmailTemplate, error) {
+	return r.c.FindOne(ctx, bson.M{"name": name, "locale": locale})
+}
+
+func (r *templateRepository) DeleteByName(ctx context.Context, name, locale string) error {
+	return r.c.DeleteOne(ctx, bson.M{"name": name, "locale": locale})
+}
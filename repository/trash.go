@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"nanosoft/background"
+	"nanosoft/models"
+	"nanosoft/query"
+	"nanosoft/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TrashRepository soft deletes documents. A trashed document has a
+// deleted_at time and the ID of the user who deleted it in deleted_by; the
+// repository's other methods no longer see it, so it can only be listed,
+// restored or purged, which deletes it for good.
+type TrashRepository[T any] interface {
+	Trash(ctx context.Context, id primitive.ObjectID, by string) error
+	Restore(ctx context.Context, id primitive.ObjectID) error
+	Purge(ctx context.Context, id primitive.ObjectID) error
+	// GetTrashed returns a document in the trash, or ErrNotFound.
+	GetTrashed(ctx context.Context, id primitive.ObjectID) (*T, error)
+	ListTrash(ctx context.Context, filter bson.M, list query.List) (query.Page[T], error)
+	// PurgeTrashed purges every document trashed before the given time.
+	PurgeTrashed(ctx context.Context, before time.Time) (int64, error)
+}
+
+// liveCollection hides trashed documents from a collection. A filter that
+// has its own deleted_at condition is left alone.
+type liveCollection[T any] struct {
+	Collection[T]
+}
+
+func live(filter bson.M) bson.M {
+	if _, ok := filter["deleted_at"]; ok {
+		return filter
+	}
+	scoped := bson.M{"deleted_at": nil}
+	for name, value := range filter {
+		scoped[name] = value
+	}
+	return scoped
+}
+
+func (c liveCollection[T]) Find(ctx context.Context, filter bson.M, list query.List) (query.Page[T], error) {
+	return c.Collection.Find(ctx, live(filter), list)
+}
+
+func (c liveCollection[T]) FindOne(ctx context.Context, filter bson.M) (*T, error) {
+	return c.Collection.FindOne(ctx, live(filter))
+}
+
+func (c liveCollection[T]) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return c.Collection.Count(ctx, live(filter))
+}
+
+func (c liveCollection[T]) UpdateOne(ctx context.Context, filter, update bson.M) error {
+	return c.Collection.UpdateOne(ctx, live(filter), update)
+}
+
+func (c liveCollection[T]) UpdateMany(ctx context.Context, filter, update bson.M) (int64, error) {
+	return c.Collection.UpdateMany(ctx, live(filter), update)
+}
+
+func (c liveCollection[T]) DeleteOne(ctx context.Context, filter bson.M) error {
+	return c.Collection.DeleteOne(ctx, live(filter))
+}
+
+func (c liveCollection[T]) DeleteMany(ctx context.Context, filter bson.M) (int64, error) {
+	return c.Collection.DeleteMany(ctx, live(filter))
+}
+
+// trash implements TrashRepository. all sees trashed documents too. The
+// version of versioned documents changes when they are trashed or
+// restored.
+type trash[T any] struct {
+	all       Collection[T]
+	versioned bool
+}
+
+var inTrash = bson.M{"$ne": nil}
+
+func (t *trash[T]) Trash(ctx context.Context, id primitive.ObjectID, by string) error {
+	return t.trash(ctx, bson.M{"_id": id, "deleted_at": nil}, by)
+}
+
+func (t *trash[T]) trash(ctx context.Context, filter bson.M, by string) error {
+	update := bson.M{"$set": bson.M{"deleted_at": time.Now(), "deleted_by": by}}
+	if t.versioned {
+		update["$inc"] = bson.M{"version": int64(1)}
+	}
+	return t.all.UpdateOne(ctx, filter, update)
+}
+
+func (t *trash[T]) Restore(ctx context.Context, id primitive.ObjectID) error {
+	update := bson.M{"$unset": bson.M{"deleted_at": "", "deleted_by": ""}}
+	if t.versioned {
+		update["$inc"] = bson.M{"version": int64(1)}
+	}
+	return t.all.UpdateOne(ctx, bson.M{"_id": id, "deleted_at": inTrash}, update)
+}
+
+func (t *trash[T]) Purge(ctx context.Context, id primitive.ObjectID) error {
+	return t.all.DeleteOne(ctx, bson.M{"_id": id, "deleted_at": inTrash})
+}
+
+func (t *trash[T]) GetTrashed(ctx context.Context, id primitive.ObjectID) (*T, error) {
+	return t.all.FindOne(ctx, bson.M{"_id": id, "deleted_at": inTrash})
+}
+
+func (t *trash[T]) ListTrash(ctx context.Context, filter bson.M, list query.List) (query.Page[T], error) {
+	trashed := bson.M{"deleted_at": inTrash}
+	for name, value := range filter {
+		trashed[name] = value
+	}
+	return t.all.Find(ctx, trashed, list)
+}
+
+func (t *trash[T]) PurgeTrashed(ctx context.Context, before time.Time) (int64, error) {
+	return t.all.DeleteMany(ctx, bson.M{"deleted_at": bson.M{"$lt": before}})
+}
+
+// PurgeTrash purges the documents of every repository that were trashed
+// before the given time, returning how many there were and the stored
+// files they referred to.
+func (s *Store) PurgeTrash(ctx context.Context, before time.Time) (int64, []string, error) {
+	var files []string
+	purges := []func() (int64, error){
+		func() (int64, error) { return purgeExpired[models.Service](ctx, s.Services, before, &files) },
+		func() (int64, error) { return purgeExpired[models.Project](ctx, s.Projects, before, &files) },
+		func() (int64, error) { return purgeExpired[models.Remark](ctx, s.Remarks, before, &files) },
+		func() (int64, error) { return purgeExpired[models.User](ctx, s.Users, before, &files) },
+		func() (int64, error) { return s.Messages.PurgeTrashed(ctx, before) },
+	}
+	var total int64
+	for _, purge := range purges {
+		n, err := purge()
+		total += n
+		if err != nil {
+			return total, files, err
+		}
+	}
+	return total, files, nil
+}
+
+// purgeExpired purges the documents of repo trashed before the given time
+// one at a time, adding the files of each to files once it is gone. One
+// restored in the meantime is left alone.
+func purgeExpired[T any, PT interface {
+	*T
+	GetID() primitive.ObjectID
+	Files() []string
+}](ctx context.Context, repo TrashRepository[T], before time.Time, files *[]string) (int64, error) {
+	expired, err := repo.ListTrash(ctx, bson.M{"deleted_at": bson.M{"$lt": before}}, query.List{})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for i := range expired.Items {
+		doc := PT(&expired.Items[i])
+		err := repo.Purge(ctx, doc.GetID())
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+		*files = append(*files, doc.Files()...)
+	}
+	return n, nil
+}
+
+// StartTrashPurge runs a job in group that, every interval, purges what has
+// been in the trash for longer than retention and deletes the files it
+// referred to from files.
+func (s *Store) StartTrashPurge(group *background.Group, retention, interval time.Duration, files storage.Storage) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	group.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			n, paths, err := s.PurgeTrash(ctx, time.Now().Add(-retention))
+			if err != nil && ctx.Err() == nil {
+				log.Println("Error purging trash:", err)
+			}
+			storage.DeleteAll(context.WithoutCancel(ctx), files, paths)
+			if n > 0 {
+				log.Printf("Purged %d documents from the trash", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
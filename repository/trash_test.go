@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"nanosoft/models"
+	"nanosoft/query"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newService(t *testing.T, s *Store, title, imagePath string) primitive.ObjectID {
+	t.Helper()
+	doc := models.Service{Service_ID: primitive.NewObjectID(), Title: &title, Version: 1}
+	if imagePath != "" {
+		doc.ImagePath = &imagePath
+	}
+	if err := s.Services.Create(context.Background(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Service_ID
+}
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	id := newService(t, s, "Hosting", "")
+	newService(t, s, "Support", "")
+
+	if err := s.Services.Trash(ctx, id, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Services.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a trashed document = %v, want ErrNotFound", err)
+	}
+	if live, _ := s.Services.List(ctx, bson.M{}, query.List{}); live.Total != 1 {
+		t.Errorf("List = %+v, want only the live document", live)
+	}
+	if err := s.Services.Update(ctx, id, bson.M{"title": "x"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of a trashed document = %v, want ErrNotFound", err)
+	}
+	if err := s.Services.Trash(ctx, id, "admin"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Trash of a trashed document = %v, want ErrNotFound", err)
+	}
+
+	trashed, err := s.Services.ListTrash(ctx, bson.M{}, query.List{})
+	if err != nil || trashed.Total != 1 || trashed.Items[0].Service_ID != id {
+		t.Fatalf("ListTrash = %+v, %v, want the trashed document", trashed, err)
+	}
+	doc, err := s.Services.GetTrashed(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Deleted_At == nil || doc.Deleted_By != "admin" || doc.Version != 2 {
+		t.Errorf("trashed document = %+v, want deleted_at, deleted_by admin and version 2", doc)
+	}
+
+	if err := s.Services.Restore(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	doc, err = s.Services.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Deleted_At != nil || doc.Deleted_By != "" || doc.Version != 3 {
+		t.Errorf("restored document = %+v, want no deleted_at or deleted_by and version 3", doc)
+	}
+	if _, err := s.Services.GetTrashed(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetTrashed of a live document = %v, want ErrNotFound", err)
+	}
+	if err := s.Services.Restore(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore of a live document = %v, want ErrNotFound", err)
+	}
+	if err := s.Services.Purge(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Purge of a live document = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Services.TrashIf(ctx, id, 1, "admin"); !errors.Is(err, ErrConflict) {
+		t.Errorf("TrashIf at an old version = %v, want ErrConflict", err)
+	}
+	if err := s.Services.TrashIf(ctx, id, 3, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Services.Purge(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Services.GetTrashed(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetTrashed of a purged document = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	expired := newService(t, s, "Old", "images/old.png")
+	restored := newService(t, s, "Back", "images/back.png")
+	live := newService(t, s, "Live", "images/live.png")
+	email := "vic@example.com"
+	message := models.Message{Message_ID: primitive.NewObjectID(), Email: &email}
+	if err := s.Messages.Create(ctx, &message); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []primitive.ObjectID{expired, restored} {
+		if err := s.Services.Trash(ctx, id, "admin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Messages.Trash(ctx, message.Message_ID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Services.Restore(ctx, restored); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing has been in the trash for an hour yet.
+	n, files, err := s.PurgeTrash(ctx, time.Now().Add(-time.Hour))
+	if err != nil || n != 0 || len(files) != 0 {
+		t.Fatalf("PurgeTrash an hour ago = %d, %v, %v, want nothing purged", n, files, err)
+	}
+
+	n, files, err = s.PurgeTrash(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || !reflect.DeepEqual(files, []string{"images/old.png"}) {
+		t.Errorf("PurgeTrash = %d, %v, want the expired service and message with images/old.png", n, files)
+	}
+	if _, err := s.Services.GetTrashed(ctx, expired); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired service is still in the trash: %v", err)
+	}
+	if _, err := s.Messages.GetTrashed(ctx, message.Message_ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired message is still in the trash: %v", err)
+	}
+	for _, id := range []primitive.ObjectID{restored, live} {
+		if _, err := s.Services.Get(ctx, id); err != nil {
+			t.Errorf("live service %s: %v", id.Hex(), err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"context"
+
+	"nanosoft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type UserRepository interface {
+	VersionedRepository[models.User]
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+}
+
+type userRepository struct {
+	versioned[models.User]
+}
+
+func (r *userRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.c.FindOne(ctx, bson.M{"email": email})
+}
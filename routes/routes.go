@@ -1,56 +1,162 @@
 package routes
 
 import (
+	"log"
+	"time"
+
+	"nanosoft/background"
+	"nanosoft/config"
 	"nanosoft/controllers"
+	"nanosoft/mail"
+	"nanosoft/middleware"
+	"nanosoft/repository"
+	"nanosoft/storage"
 
 	"github.com/gin-gonic/gin"
 )
 
-func UserRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup) {
-	publicRoutes.POST("/user/register", controllers.Register())
-	publicRoutes.POST("/user/login", controllers.Login())
-	publicRoutes.GET("/user/refresh-token", controllers.RefreshToken())
+// NewRouter builds the whole API on top of store. Passing
+// repository.NewMemoryStore() gives a router that can be exercised with
+// httptest without a database. Email is queued on outbox, uploads are kept
+// in files and other work that outlives a request is started on workers.
+func NewRouter(store *repository.Store, outbox *mail.Outbox, workers *background.Group, files storage.Storage) *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Logger())
+	// Client IPs key the rate limits, the login lockout and the audit log,
+	// so X-Forwarded-For is only believed from the proxies listed here.
+	if err := router.SetTrustedProxies(config.List("TRUSTED_PROXIES", nil)); err != nil {
+		log.Println("Error in TRUSTED_PROXIES, trusting no proxy:", err)
+		router.SetTrustedProxies(nil)
+	}
+
+	publicRoutes := router.Group("/")
+
+	authenticatedRoutes := router.Group("/")
+	authenticatedRoutes.Use(middleware.Authentication(store.Revocations))
 
-	authenticatedRoutes.GET("/user/me", controllers.GetUserInfo())
-	authenticatedRoutes.PUT("/user/update-info", controllers.UpdateUserInfo())
-	authenticatedRoutes.PUT("/user/update-password", controllers.UpdateUserPassword())
+	adminRoutes := router.Group("/")
+	adminRoutes.Use(middleware.Authentication(store.Revocations))
+	adminRoutes.Use(middleware.AuthorizeRole([]int{1, 2}))
+	adminRoutes.Use(middleware.Audit(store.Audit))
 
-	adminRoutes.GET("/admin/get-all-users", controllers.GetAllUsers())
-	adminRoutes.PUT("/admin/update-user-role", controllers.UpdateUserRole())
-	adminRoutes.DELETE("/admin/delete-user/:id", controllers.DeleteUser())
+	templates := mail.NewTemplates(store.Templates, config.String("MAIL_TEMPLATE_DIR", ""))
+
+	UserRoutes(publicRoutes, authenticatedRoutes, adminRoutes, controllers.NewUserController(store, outbox, templates, workers, files), store.Buckets)
+	ServiceRoutes(publicRoutes, authenticatedRoutes, adminRoutes, controllers.NewServiceResource(store.Services, files))
+	ProjectRoutes(publicRoutes, authenticatedRoutes, adminRoutes, controllers.NewProjectResource(store.Projects, files))
+	RemarkRoutes(publicRoutes, authenticatedRoutes, adminRoutes, controllers.NewRemarkResource(store.Remarks, files))
+	EmailRoutes(publicRoutes, authenticatedRoutes, adminRoutes, controllers.NewEmailController(store, outbox, templates), store.Buckets)
+	TemplateRoutes(adminRoutes, controllers.NewTemplateController(templates))
+	BlogRoutes(publicRoutes, authenticatedRoutes, adminRoutes, controllers.NewBlogController(store.Posts, files))
+	AuditRoutes(adminRoutes, controllers.NewAuditController(store))
+	UploadRoutes(publicRoutes, authenticatedRoutes, adminRoutes, controllers.NewUploadController(store, files), store.Buckets)
+	return router
 }
 
-func ServiceRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup) {
-	publicRoutes.GET("/service/get-all", controllers.GetAllServices())
-	publicRoutes.GET("/service/get-one/:id", controllers.GetOneService())
+func UserRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup, users *controllers.UserController, buckets repository.BucketRepository) {
+	registerLimit := middleware.RateLimit(buckets,
+		middleware.LoadLimit("RATE_LIMIT_REGISTER", middleware.Limit{Name: "register", Requests: 10, Per: time.Hour}),
+		middleware.ByIP)
+	loginLimit := middleware.RateLimit(buckets,
+		middleware.LoadLimit("RATE_LIMIT_LOGIN", middleware.Limit{Name: "login", Requests: 20, Per: time.Minute}),
+		middleware.ByIP)
+	// Shared by the routes that send mail to an arbitrary address.
+	accountMailLimit := middleware.RateLimit(buckets,
+		middleware.LoadLimit("RATE_LIMIT_ACCOUNT_MAIL", middleware.Limit{Name: "account-mail", Requests: 5, Per: time.Hour}),
+		middleware.ByIP)
+
+	publicRoutes.POST("/user/register", registerLimit, users.Register())
+	publicRoutes.POST("/user/login", loginLimit, users.Login())
+	publicRoutes.GET("/user/refresh-token", users.RefreshToken())
+	publicRoutes.POST("/user/forgot-password", accountMailLimit, users.ForgotPassword())
+	publicRoutes.POST("/user/reset-password", loginLimit, users.ResetPassword())
+	publicRoutes.POST("/user/verify-email", users.VerifyEmail())
+	publicRoutes.POST("/user/resend-verification", accountMailLimit, users.ResendVerification())
 
-	adminRoutes.POST("/service/create", controllers.CreateService())
-	adminRoutes.PUT("/service/update/:id", controllers.UpdateService())
-	adminRoutes.DELETE("/service/delete/:id", controllers.DeleteService())
+	authenticatedRoutes.GET("/user/me", users.GetUserInfo())
+	authenticatedRoutes.PUT("/user/update-info", users.UpdateUserInfo())
+	authenticatedRoutes.PUT("/user/update-password", users.UpdateUserPassword())
+	authenticatedRoutes.POST("/user/logout", users.Logout())
+	authenticatedRoutes.POST("/user/logout-all", users.LogoutAll())
+
+	adminRoutes.GET("/admin/get-all-users", users.GetAllUsers())
+	adminRoutes.PUT("/admin/update-user-role", users.UpdateUserRole())
+	adminRoutes.DELETE("/admin/delete-user/:id", users.DeleteUser())
+	adminRoutes.GET("/admin/trashed-users", users.GetTrashedUsers())
+	adminRoutes.POST("/admin/restore-user/:id", users.RestoreUser())
+	adminRoutes.DELETE("/admin/purge-user/:id", users.PurgeUser())
+	adminRoutes.POST("/admin/revoke-sessions/:id", users.RevokeUserSessions())
+	adminRoutes.POST("/admin/unlock-user/:id", users.UnlockUser())
+	adminRoutes.GET("/admin/lockouts", users.GetLockouts())
 }
 
-func ProjectRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup) {
-	publicRoutes.GET("/project/get-all", controllers.GetAllProjects())
-	publicRoutes.GET("/project/get-one/:id", controllers.GetOneProject())
+func ServiceRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup, services *controllers.ServiceResource) {
+	services.Routes(publicRoutes, authenticatedRoutes, adminRoutes)
+}
 
-	adminRoutes.POST("/project/create", controllers.CreateProject())
-	adminRoutes.PUT("/project/update/:id", controllers.UpdateProject())
-	adminRoutes.DELETE("/project/delete/:id", controllers.DeleteProject())
+func ProjectRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup, projects *controllers.ProjectResource) {
+	projects.Routes(publicRoutes, authenticatedRoutes, adminRoutes)
 }
 
-func RemarkRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup) {
-	publicRoutes.GET("/remark/get-all", controllers.GetAllRemarks())
-	publicRoutes.GET("/remark/get-one/:id", controllers.GetOneRemark())
+func RemarkRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup, remarks *controllers.RemarkResource) {
+	remarks.Routes(publicRoutes, authenticatedRoutes, adminRoutes)
+}
+
+func EmailRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup, emails *controllers.EmailController, buckets repository.BucketRepository) {
+	contactLimit := middleware.RateLimit(buckets,
+		middleware.LoadLimit("RATE_LIMIT_CONTACT", middleware.Limit{Name: "contact", Requests: 5, Per: time.Hour}),
+		middleware.ByIP)
+
+	publicRoutes.GET("/email/form-token", emails.GetFormToken())
+	publicRoutes.POST("/email/create", contactLimit, emails.CreateEmail())
 
-	adminRoutes.POST("/remark/create", controllers.CreateRemark())
-	adminRoutes.PUT("/remark/update/:id", controllers.UpdateRemark())
-	adminRoutes.DELETE("/remark/delete/:id", controllers.DeleteRemark())
+	adminRoutes.GET("/email/get-all", emails.GetAllEmails())
+	adminRoutes.GET("/email/get-one/:id", emails.GetOneEmail())
+	adminRoutes.DELETE("/email/delete/:id", emails.DeleteEmail())
+	adminRoutes.GET("/email/trash", emails.GetEmailTrash())
+	adminRoutes.POST("/email/restore/:id", emails.RestoreEmail())
+	adminRoutes.DELETE("/email/purge/:id", emails.PurgeEmail())
+	adminRoutes.PUT("/email/update-status/:id", emails.UpdateEmailStatus())
+	adminRoutes.PUT("/email/assign/:id", emails.AssignEmail())
+	adminRoutes.PUT("/email/update-tags/:id", emails.UpdateEmailTags())
+	adminRoutes.POST("/email/reply/:id", emails.ReplyToEmail())
+	adminRoutes.POST("/email/notes/:id", emails.AddEmailNote())
+	adminRoutes.DELETE("/email/notes/:id/:note_id", emails.DeleteEmailNote())
+	adminRoutes.GET("/email/outbox", emails.GetOutbox())
+	adminRoutes.POST("/email/outbox/retry/:id", emails.RetryOutboundEmail())
 }
 
-func EmailRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup) {
-	publicRoutes.POST("/email/create", controllers.CreateEmail())
+func TemplateRoutes(adminRoutes *gin.RouterGroup, templates *controllers.TemplateController) {
+	adminRoutes.GET("/email/templates", templates.GetAllTemplates())
+	adminRoutes.GET("/email/templates/:name", templates.GetTemplate())
+	adminRoutes.PUT("/email/templates/:name", templates.UpdateTemplate())
+	adminRoutes.DELETE("/email/templates/:name", templates.ResetTemplate())
+	adminRoutes.POST("/email/templates/:name/preview", templates.PreviewTemplate())
+}
+
+func BlogRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup, blog *controllers.BlogController) {
+	publicRoutes.GET("/blog/get-all", blog.GetAllPosts())
+	publicRoutes.GET("/blog/get-one/:slug", blog.GetOnePost())
+
+	adminRoutes.GET("/blog/admin/get-all", blog.GetAllPostsAdmin())
+	adminRoutes.POST("/blog/create", blog.CreatePost())
+	adminRoutes.PUT("/blog/update/:id", blog.UpdatePost())
+	adminRoutes.DELETE("/blog/delete/:id", blog.DeletePost())
+}
+
+func AuditRoutes(adminRoutes *gin.RouterGroup, audit *controllers.AuditController) {
+	adminRoutes.GET("/admin/audit-log", audit.GetAuditLog())
+	adminRoutes.GET("/admin/audit-log/export", audit.ExportAuditLog())
+}
+
+func UploadRoutes(publicRoutes, authenticatedRoutes, adminRoutes *gin.RouterGroup, uploads *controllers.UploadController, buckets repository.BucketRepository) {
+	uploadLimit := middleware.RateLimit(buckets,
+		middleware.LoadLimit("RATE_LIMIT_UPLOAD", middleware.Limit{Name: "upload", Requests: 30, Per: time.Hour}),
+		middleware.ByUser)
+
+	publicRoutes.GET("/uploads/*path", uploads.ServeFile())
+
+	authenticatedRoutes.POST("/user/avatar", uploadLimit, uploads.UploadAvatar())
 
-	adminRoutes.GET("/email/get-all", controllers.GetAllEmails())
-	adminRoutes.GET("/email/get-one/:id", controllers.GetOneEmail())
-	adminRoutes.DELETE("/email/delete/:id", controllers.DeleteEmail())
+	adminRoutes.POST("/upload/image", uploads.UploadImage())
 }
@@ -0,0 +1,976 @@
+package routes
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"nanosoft/background"
+	"nanosoft/controllers"
+	"nanosoft/mail"
+	"nanosoft/models"
+	"nanosoft/repository"
+	"nanosoft/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	os.Setenv("SECRET_KEY", "test-secret")
+	os.Exit(m.Run())
+}
+
+// testServer is the whole API on a memory store, with mail recorded
+// instead of sent.
+type testServer struct {
+	t      *testing.T
+	router http.Handler
+	store  *repository.Store
+	mails  *mail.Recorder
+	files  storage.Storage
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	store := repository.NewMemoryStore()
+	mails := mail.NewRecorder()
+	cfg := mail.LoadOutboxConfig()
+	cfg.PollInterval = 10 * time.Millisecond
+	outbox := mail.NewOutbox(store.Outbox, mails, cfg)
+	workers := background.NewGroup()
+	outbox.Start(workers)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		workers.Stop(ctx)
+	})
+	files, err := storage.NewLocal(storage.Config{Dir: t.TempDir(), BaseURL: "http://localhost:8000/uploads"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testServer{t: t, router: NewRouter(store, outbox, workers, files), store: store, mails: mails, files: files}
+}
+
+// request sends body as JSON, with token as the access token unless it is
+// empty.
+func (s *testServer) request(method, path, body, token string) *httptest.ResponseRecorder {
+	return s.requestHeader(method, path, body, token, "", "")
+}
+
+// requestHeader is request with the header name set to value, unless name
+// is empty.
+func (s *testServer) requestHeader(method, path, body, token, name, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("token", token)
+	}
+	if name != "" {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// expect sends the request and fails the test unless it answers status.
+// The JSON response is decoded into out unless it is nil.
+func (s *testServer) expect(status int, method, path, body, token string, out interface{}) {
+	s.t.Helper()
+	w := s.request(method, path, body, token)
+	if w.Code != status {
+		s.t.Fatalf("%s %s = %d %s, want %d", method, path, w.Code, w.Body.String(), status)
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: decoding %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+}
+
+// mailTo waits for the outbox to deliver a message with subject to
+// address and returns the last one.
+func (s *testServer) mailTo(address, subject string) mail.Message {
+	s.t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		messages := s.mails.Messages()
+		for i := len(messages) - 1; i >= 0; i-- {
+			if len(messages[i].To) == 1 && messages[i].To[0] == address && messages[i].Subject == subject {
+				return messages[i]
+			}
+		}
+	}
+	s.t.Fatalf("no mail %q was sent to %s", subject, address)
+	return mail.Message{}
+}
+
+// storeFile saves a file under name as if it had been uploaded.
+func (s *testServer) storeFile(name string) {
+	s.t.Helper()
+	if err := s.files.Save(context.Background(), name, strings.NewReader("image")); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// stored reports whether a file is kept under name.
+func (s *testServer) stored(name string) bool {
+	f, err := s.files.Open(context.Background(), name)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+type tokens struct {
+	Token         string `json:"token"`
+	Refresh_Token string `json:"refresh_token"`
+}
+
+// signUp registers a user with role and logs them in.
+func (s *testServer) signUp(email string, role int) tokens {
+	s.t.Helper()
+	s.expect(http.StatusCreated, "POST", "/user/register", `{"name":"Test","email":"`+email+`","password":"secret1"}`, "", nil)
+	if role != 0 {
+		ctx := context.Background()
+		user, err := s.store.Users.FindByEmail(ctx, email)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		if err := s.store.Users.Update(ctx, user.ID, bson.M{"role": role}); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	var out tokens
+	s.expect(http.StatusFound, "POST", "/user/login", `{"email":"`+email+`","password":"secret1"}`, "", &out)
+	return out
+}
+
+func TestRegisterLoginRefresh(t *testing.T) {
+	s := newTestServer(t)
+
+	user := s.signUp("ann@example.com", 0)
+	if user.Token == "" || user.Refresh_Token == "" {
+		t.Fatalf("login returned no tokens: %+v", user)
+	}
+	s.expect(http.StatusBadRequest, "POST", "/user/register", `{"name":"Test","email":"ann@example.com","password":"secret1"}`, "", nil)
+	s.expect(http.StatusBadRequest, "POST", "/user/register", `{"name":"Test","email":"not-an-email","password":"secret1"}`, "", nil)
+	s.expect(http.StatusInternalServerError, "POST", "/user/login", `{"email":"ann@example.com","password":"wrong"}`, "", nil)
+
+	var me struct {
+		Email string `json:"email"`
+	}
+	s.expect(http.StatusOK, "GET", "/user/me", "", user.Token, &me)
+	if me.Email != "ann@example.com" {
+		t.Errorf("/user/me email = %q, want ann@example.com", me.Email)
+	}
+	s.expect(http.StatusUnauthorized, "GET", "/user/me", "", user.Refresh_Token, nil)
+
+	var refreshed struct {
+		Access_Token  string `json:"access_token"`
+		Refresh_Token string `json:"refresh_token"`
+	}
+	s.expect(http.StatusOK, "GET", "/user/refresh-token?refreshToken="+user.Refresh_Token, "", "", &refreshed)
+	s.expect(http.StatusOK, "GET", "/user/me", "", refreshed.Access_Token, nil)
+
+	// Reusing a rotated refresh token revokes the whole family.
+	s.expect(http.StatusUnauthorized, "GET", "/user/refresh-token?refreshToken="+user.Refresh_Token, "", "", nil)
+	s.expect(http.StatusUnauthorized, "GET", "/user/refresh-token?refreshToken="+refreshed.Refresh_Token, "", "", nil)
+
+	s.expect(http.StatusOK, "POST", "/user/logout", "", user.Token, nil)
+	s.expect(http.StatusUnauthorized, "GET", "/user/me", "", user.Token, nil)
+}
+
+func TestPasswordLength(t *testing.T) {
+	s := newTestServer(t)
+	user := s.signUp("ann@example.com", 0).Token
+
+	// 30 characters but 90 bytes, more than bcrypt accepts.
+	long := strings.Repeat("€", 30)
+	tests := []struct {
+		name, method, path, body, token, field string
+	}{
+		{"register", "POST", "/user/register", `{"name":"Test","email":"bob@example.com","password":"` + long + `"}`, "", "password"},
+		{"update", "PUT", "/user/update-password", `{"old_password":"secret1","new_password":"` + long + `"}`, user, "new_password"},
+		{"reset", "POST", "/user/reset-password", `{"token":"x","new_password":"` + long + `"}`, "", "new_password"},
+	}
+	for _, tt := range tests {
+		var out struct {
+			Fields []controllers.FieldError `json:"fields"`
+		}
+		s.expect(http.StatusBadRequest, tt.method, tt.path, tt.body, tt.token, &out)
+		if len(out.Fields) != 1 || out.Fields[0].Field != tt.field || out.Fields[0].Code != "maxbytes" {
+			t.Errorf("%s: fields = %+v, want %s maxbytes", tt.name, out.Fields, tt.field)
+		}
+	}
+
+	// 24 characters are 72 bytes, which bcrypt still takes.
+	fits := strings.Repeat("€", 24)
+	s.expect(http.StatusOK, "PUT", "/user/update-password", `{"old_password":"secret1","new_password":"`+fits+`"}`, user, nil)
+	s.expect(http.StatusFound, "POST", "/user/login", `{"email":"ann@example.com","password":"`+fits+`"}`, "", nil)
+}
+
+type listed struct {
+	Items []map[string]interface{} `json:"items"`
+	Total int64                    `json:"total"`
+}
+
+func TestResourceCRUD(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+	user := s.signUp("user@example.com", 0).Token
+
+	tests := []struct {
+		name   string
+		create string
+		field  string
+		update string
+		patch  string
+	}{
+		{"service", `{"title":"Hosting","description":"Servers"}`, "title", `{"title":"Managed hosting"}`, `{"description":"Racks"}`},
+		{"project", `{"title":"Shop","tech":"Go"}`, "title", `{"title":"Webshop"}`, `{"tech":"Go, Vue"}`},
+		{"remark", `{"name":"Ann","remark":"Great work"}`, "name", `{"name":"Bob","remark":"Great work"}`, `{"role":"CTO"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.t = t
+			base := "/" + tt.name
+
+			s.expect(http.StatusInternalServerError, "POST", base+"/create", tt.create, "", nil)
+			s.expect(http.StatusForbidden, "POST", base+"/create", tt.create, user, nil)
+			s.expect(http.StatusBadRequest, "POST", base+"/create", `{}`, admin, nil)
+			s.expect(http.StatusCreated, "POST", base+"/create", tt.create, admin, nil)
+
+			var all listed
+			s.expect(http.StatusOK, "GET", base+"/get-all", "", "", &all)
+			if all.Total != 1 || len(all.Items) != 1 {
+				t.Fatalf("get-all = %+v, want one item", all)
+			}
+			id := all.Items[0]["_id"].(string)
+
+			var one map[string]interface{}
+			s.expect(http.StatusOK, "GET", base+"/get-one/"+id, "", "", &one)
+			before := one[tt.field]
+
+			var updated map[string]interface{}
+			s.expect(http.StatusOK, "PUT", base+"/update/"+id, tt.update, admin, &updated)
+			if updated[tt.field] == before {
+				t.Errorf("update left %s at %v", tt.field, before)
+			}
+			if updated["version"] != 2.0 {
+				t.Errorf("version after update = %v, want 2", updated["version"])
+			}
+
+			var patched map[string]interface{}
+			s.expect(http.StatusOK, "PATCH", base+"/update/"+id, tt.patch, admin, &patched)
+			if patched[tt.field] != updated[tt.field] {
+				t.Errorf("patch changed %s from %v to %v", tt.field, updated[tt.field], patched[tt.field])
+			}
+			s.expect(http.StatusBadRequest, "PATCH", base+"/update/"+id, `{"version":9}`, admin, nil)
+
+			s.expect(http.StatusOK, "DELETE", base+"/delete/"+id, "", admin, nil)
+			s.expect(http.StatusNotFound, "GET", base+"/get-one/"+id, "", "", nil)
+			s.expect(http.StatusOK, "POST", base+"/restore/"+id, "", admin, nil)
+			s.expect(http.StatusOK, "GET", base+"/get-one/"+id, "", "", nil)
+			s.expect(http.StatusOK, "DELETE", base+"/delete/"+id, "", admin, nil)
+			s.expect(http.StatusOK, "DELETE", base+"/purge/"+id, "", admin, nil)
+			s.expect(http.StatusNotFound, "POST", base+"/restore/"+id, "", admin, nil)
+			s.expect(http.StatusBadRequest, "GET", base+"/get-one/not-an-id", "", "", nil)
+		})
+	}
+}
+
+func TestBlogCRUD(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+
+	var created struct {
+		Slug string `json:"slug"`
+	}
+	s.expect(http.StatusCreated, "POST", "/blog/create", `{"title":"Hello World","body":"First","tags":["news"]}`, admin, &created)
+	if created.Slug != "hello-world" {
+		t.Fatalf("slug = %q, want hello-world", created.Slug)
+	}
+	// Drafts are only listed for admins.
+	s.expect(http.StatusNotFound, "GET", "/blog/get-one/hello-world", "", "", nil)
+
+	var drafts listed
+	s.expect(http.StatusOK, "GET", "/blog/admin/get-all", "", admin, &drafts)
+	if len(drafts.Items) != 1 {
+		t.Fatalf("admin get-all = %+v, want one post", drafts)
+	}
+	id := drafts.Items[0]["_id"].(string)
+
+	s.expect(http.StatusOK, "PUT", "/blog/update/"+id, `{"title":"Hello World","status":"published"}`, admin, nil)
+	var post map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/blog/get-one/hello-world", "", "", &post)
+	if post["body"] != "First" || len(post["tags"].([]interface{})) != 1 {
+		t.Errorf("post after update = %v, want the body and tags kept", post)
+	}
+
+	// A post keeps its status when the update leaves it out.
+	s.expect(http.StatusOK, "PUT", "/blog/update/"+id, `{"title":"Hello World","body":"Second","tags":[]}`, admin, nil)
+	s.expect(http.StatusOK, "GET", "/blog/get-one/hello-world", "", "", &post)
+	if post["body"] != "Second" || len(post["tags"].([]interface{})) != 0 {
+		t.Errorf("post after update = %v, want the new body and no tags", post)
+	}
+	var published listed
+	s.expect(http.StatusOK, "GET", "/blog/get-all", "", "", &published)
+	if len(published.Items) != 1 {
+		t.Errorf("get-all = %+v, want one post", published)
+	}
+
+	s.expect(http.StatusOK, "DELETE", "/blog/delete/"+id, "", admin, nil)
+	s.expect(http.StatusNotFound, "GET", "/blog/get-one/hello-world", "", "", nil)
+}
+
+func TestEmailCRUD(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+
+	s.expect(http.StatusBadRequest, "POST", "/email/create", `{"name":"Vic","email":"nope","message":"Hi"}`, "", nil)
+	// Fields only admins set are ignored in a submission.
+	s.expect(http.StatusCreated, "POST", "/email/create", `{"name":"Vic","email":"vic@example.com","message":"Hi there","status":"archived","tags":["vip"],"deleted_at":"2026-01-01T00:00:00Z","deleted_by":"x"}`, "", nil)
+
+	var all listed
+	s.expect(http.StatusOK, "GET", "/email/get-all", "", admin, &all)
+	if len(all.Items) != 1 {
+		t.Fatalf("get-all = %+v, want one message", all)
+	}
+	id := all.Items[0]["_id"].(string)
+	if item := all.Items[0]; item["status"] != "new" || len(item["tags"].([]interface{})) != 0 || item["deleted_at"] != nil || item["deleted_by"] != "" {
+		t.Errorf("submitted message = %v, want a new one outside the trash", item)
+	}
+
+	s.expect(http.StatusOK, "GET", "/email/get-one/"+id, "", admin, nil)
+	s.expect(http.StatusOK, "PUT", "/email/update-status/"+id, `{"status":"read"}`, admin, nil)
+	var one map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/email/get-one/"+id, "", admin, &one)
+	if one["status"] != "read" {
+		t.Errorf("status = %v, want read", one["status"])
+	}
+
+	s.expect(http.StatusOK, "DELETE", "/email/delete/"+id, "", admin, nil)
+	s.expect(http.StatusNotFound, "GET", "/email/get-one/"+id, "", admin, nil)
+	s.expect(http.StatusOK, "POST", "/email/restore/"+id, "", admin, nil)
+	s.expect(http.StatusOK, "DELETE", "/email/delete/"+id, "", admin, nil)
+	s.expect(http.StatusOK, "DELETE", "/email/purge/"+id, "", admin, nil)
+}
+
+func TestContactNotificationMail(t *testing.T) {
+	t.Setenv("SMIP_RECEPT_MAIL", "inbox@example.com")
+	s := newTestServer(t)
+
+	var created struct {
+		Reference string `json:"reference"`
+	}
+	s.expect(http.StatusCreated, "POST", "/email/create", `{"name":"Vic","email":"vic@example.com","company_name":"Acme","message":"Can you build a shop?"}`, "", &created)
+
+	msg := s.mailTo("inbox@example.com", "New message from Vic (Acme)")
+	for _, want := range []string{"vic@example.com", "Can you build a shop?", created.Reference} {
+		if !strings.Contains(msg.Text, want) {
+			t.Errorf("notification text %q does not contain %q", msg.Text, want)
+		}
+	}
+	if msg.Headers["Message-ID"] == "" {
+		t.Error("notification has no Message-ID")
+	}
+}
+
+var mailLink = regexp.MustCompile(`https?://\S+`)
+
+func TestPasswordResetMail(t *testing.T) {
+	s := newTestServer(t)
+	s.signUp("ann@example.com", 0)
+
+	s.expect(http.StatusOK, "POST", "/user/forgot-password", `{"email":"ann@example.com"}`, "", nil)
+	resetToken := linkToken(t, s.mailTo("ann@example.com", "Reset your password"))
+
+	// A password bcrypt cannot hash is refused without using up the link.
+	s.expect(http.StatusBadRequest, "POST", "/user/reset-password", `{"token":"`+resetToken+`","new_password":"`+strings.Repeat("x", 73)+`"}`, "", nil)
+	s.expect(http.StatusOK, "POST", "/user/reset-password", `{"token":"`+resetToken+`","new_password":"secret2"}`, "", nil)
+	s.expect(http.StatusBadRequest, "POST", "/user/reset-password", `{"token":"`+resetToken+`","new_password":"secret3"}`, "", nil)
+	s.expect(http.StatusFound, "POST", "/user/login", `{"email":"ann@example.com","password":"secret2"}`, "", nil)
+}
+
+func TestResourcePatch(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+	s.expect(http.StatusCreated, "POST", "/project/create", `{"title":"Shop","images":[{"image":"https://example.com/a.png"}]}`, admin, nil)
+	var all listed
+	s.expect(http.StatusOK, "GET", "/project/get-all", "", "", &all)
+	id := all.Items[0]["_id"].(string)
+
+	patch := func(contentType, ifMatch, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest("PATCH", "/project/update/"+id, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("token", admin)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		var out map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &out)
+		return w, out
+	}
+
+	readonly := []struct {
+		name, contentType, body, field string
+	}{
+		{"merge patch", "application/merge-patch+json", `{"title":"Webshop","version":7}`, "version"},
+		{"replace", "application/json-patch+json", `[{"op":"replace","path":"/_id","value":"x"}]`, "_id"},
+		{"remove", "application/json-patch+json", `[{"op":"remove","path":"/created_at"}]`, "created_at"},
+		{"move from", "application/json-patch+json", `[{"op":"move","from":"/deleted_by","path":"/t1"}]`, "deleted_by"},
+		{"copy to", "application/json-patch+json", `[{"op":"copy","from":"/title","path":"/version"}]`, "version"},
+	}
+	for _, tt := range readonly {
+		w, out := patch(tt.contentType, "", tt.body)
+		fields, _ := out["fields"].([]interface{})
+		if w.Code != http.StatusBadRequest || len(fields) != 1 {
+			t.Errorf("%s: PATCH = %d %s, want a readonly error", tt.name, w.Code, w.Body.String())
+			continue
+		}
+		if field := fields[0].(map[string]interface{}); field["field"] != tt.field || field["code"] != "readonly" {
+			t.Errorf("%s: error = %v, want %s readonly", tt.name, field, tt.field)
+		}
+	}
+
+	// Testing a read-only field changes nothing, so it is allowed.
+	w, out := patch("application/json-patch+json", "", `[{"op":"test","path":"/version","value":1},{"op":"add","path":"/images/-","value":{"image":"https://example.com/b.png"}}]`)
+	if w.Code != http.StatusOK || len(out["images"].([]interface{})) != 2 {
+		t.Fatalf("PATCH = %d %s, want two images", w.Code, w.Body.String())
+	}
+	if w, _ := patch("application/json-patch+json", "", `[{"op":"test","path":"/version","value":1}]`); w.Code != http.StatusConflict {
+		t.Errorf("failed test op = %d, want 409", w.Code)
+	}
+	if w, _ := patch("application/json-patch+json", "", `[{"op":"remove","path":"/images/5"}]`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("remove past the end = %d, want 422", w.Code)
+	}
+	if w, _ := patch("application/json-patch+json", `"`+id+`-1"`, `[{"op":"replace","path":"/title","value":"Old"}]`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match = %d, want 412", w.Code)
+	}
+	if w, _ := patch("application/json-patch+json", `"`+id+`-2"`, `[{"op":"replace","path":"/title","value":""}]`); w.Code != http.StatusBadRequest {
+		t.Errorf("blank title = %d, want 400", w.Code)
+	}
+	if w, _ := patch("text/plain", "", `{}`); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain = %d, want 415", w.Code)
+	}
+}
+
+func TestEmailDeliveries(t *testing.T) {
+	t.Setenv("SMIP_RECEPT_MAIL", "inbox@example.com")
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+
+	s.expect(http.StatusCreated, "POST", "/email/create", `{"name":"Vic","email":"vic@example.com","message":"Hi there"}`, "", nil)
+	s.mailTo("inbox@example.com", "New message from Vic")
+	var all listed
+	s.expect(http.StatusOK, "GET", "/email/get-all", "", admin, &all)
+	id := all.Items[0]["_id"].(string)
+
+	s.expect(http.StatusCreated, "POST", "/email/reply/"+id, `{"subject":"About your message","body":"Thanks, we will call you."}`, admin, nil)
+	s.mailTo("vic@example.com", "About your message")
+
+	var message struct {
+		Deliveries []struct {
+			Kind     string `json:"kind"`
+			To       string `json:"to"`
+			Status   string `json:"status"`
+			Attempts int    `json:"attempts"`
+		} `json:"deliveries"`
+		Thread []struct {
+			Status   string `json:"status"`
+			Attempts int    `json:"attempts"`
+		} `json:"thread"`
+	}
+	s.expect(http.StatusOK, "GET", "/email/get-one/"+id, "", admin, &message)
+	if len(message.Deliveries) != 2 {
+		t.Fatalf("deliveries = %+v, want the notification and the reply", message.Deliveries)
+	}
+	notification, reply := message.Deliveries[0], message.Deliveries[1]
+	if notification.Kind != "contact_notification" || notification.To != "inbox@example.com" || notification.Status != "sent" || notification.Attempts != 1 {
+		t.Errorf("notification delivery = %+v", notification)
+	}
+	if reply.Kind != "contact_reply" || reply.To != "vic@example.com" || reply.Status != "sent" {
+		t.Errorf("reply delivery = %+v", reply)
+	}
+	if len(message.Thread) != 1 || message.Thread[0].Status != "sent" || message.Thread[0].Attempts != 1 {
+		t.Errorf("thread = %+v, want the reply sent", message.Thread)
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	t.Setenv("LOGIN_MAX_ACCOUNT_FAILURES", "2")
+	t.Setenv("LOGIN_MAX_IP_FAILURES", "2")
+	t.Setenv("LOGIN_DELAY_AFTER", "0")
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+	s.signUp("ann@example.com", 0)
+	user, err := s.store.Users.FindByEmail(context.Background(), "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := `{"email":"ann@example.com","password":"wrong1"}`
+	right := `{"email":"ann@example.com","password":"secret1"}`
+	s.expect(http.StatusInternalServerError, "POST", "/user/login", wrong, "", nil)
+	s.expect(http.StatusInternalServerError, "POST", "/user/login", wrong, "", nil)
+	s.expect(http.StatusTooManyRequests, "POST", "/user/login", right, "", nil)
+
+	// The address that failed is locked out too, and is only unlocked
+	// when the admin names it.
+	s.expect(http.StatusOK, "POST", "/admin/unlock-user/"+user.ID.Hex(), "", admin, nil)
+	s.expect(http.StatusTooManyRequests, "POST", "/user/login", right, "", nil)
+	s.expect(http.StatusBadRequest, "POST", "/admin/unlock-user/"+user.ID.Hex()+"?ip=nowhere", "", admin, nil)
+	s.expect(http.StatusOK, "POST", "/admin/unlock-user/"+user.ID.Hex()+"?ip=192.0.2.1", "", admin, nil)
+	s.expect(http.StatusFound, "POST", "/user/login", right, "", nil)
+
+	var lockouts listed
+	s.expect(http.StatusOK, "GET", "/admin/lockouts", "", admin, &lockouts)
+	if len(lockouts.Items) != 2 {
+		t.Fatalf("lockouts = %+v, want the account and the address", lockouts)
+	}
+	for _, lockout := range lockouts.Items {
+		if lockout["unlocked_by"] == "" || lockout["unlocked_at"] == nil {
+			t.Errorf("lockout %v was not recorded as unlocked", lockout)
+		}
+	}
+}
+
+func TestReplacedFilesDeleted(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+
+	user, err := s.store.Users.FindByEmail(context.Background(), "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := models.AvatarDir(user.User_ID) + "/first.png"
+	second := models.AvatarDir(user.User_ID) + "/second.png"
+	s.storeFile(first)
+	s.storeFile(second)
+	s.expect(http.StatusOK, "PUT", "/user/update-info", `{"name":"Ann","avatar_path":"`+first+`"}`, admin, nil)
+
+	// Leaving the avatar out keeps it.
+	var me map[string]interface{}
+	s.expect(http.StatusOK, "PUT", "/user/update-info", `{"name":"Bob"}`, admin, &me)
+	if me["avatar_path"] != first || !s.stored(first) {
+		t.Errorf("avatar_path after update = %v, want %s kept", me["avatar_path"], first)
+	}
+	s.expect(http.StatusOK, "PUT", "/user/update-info", `{"name":"Bob","avatar_path":"`+second+`"}`, admin, nil)
+	if s.stored(first) || !s.stored(second) {
+		t.Errorf("after replacing the avatar %s stored = %v, %s stored = %v", first, s.stored(first), second, s.stored(second))
+	}
+	s.expect(http.StatusOK, "PUT", "/user/update-info", `{"name":"Bob","avatar_path":""}`, admin, nil)
+	if s.stored(second) {
+		t.Errorf("cleared avatar %s is still stored", second)
+	}
+
+	s.storeFile("images/a.png")
+	s.storeFile("images/b.png")
+	s.expect(http.StatusCreated, "POST", "/service/create", `{"title":"Hosting","description":"Servers","image_path":"images/a.png"}`, admin, nil)
+	var services listed
+	s.expect(http.StatusOK, "GET", "/service/get-all", "", "", &services)
+	id := services.Items[0]["_id"].(string)
+	s.expect(http.StatusOK, "PATCH", "/service/update/"+id, `{"title":"Managed hosting"}`, admin, nil)
+	if !s.stored("images/a.png") {
+		t.Error("a patch leaving the image alone deleted it")
+	}
+	s.expect(http.StatusOK, "PUT", "/service/update/"+id, `{"title":"Hosting","image_path":"images/b.png"}`, admin, nil)
+	if s.stored("images/a.png") || !s.stored("images/b.png") {
+		t.Error("replacing the service image did not delete only the old one")
+	}
+
+	s.storeFile("images/c.png")
+	s.storeFile("images/d.png")
+	s.expect(http.StatusCreated, "POST", "/blog/create", `{"title":"Hello","cover":{"image_path":"images/c.png"}}`, admin, nil)
+	var posts listed
+	s.expect(http.StatusOK, "GET", "/blog/admin/get-all", "", admin, &posts)
+	id = posts.Items[0]["_id"].(string)
+	s.expect(http.StatusOK, "PUT", "/blog/update/"+id, `{"title":"Hello","body":"Text"}`, admin, nil)
+	if !s.stored("images/c.png") {
+		t.Error("an update leaving the cover out deleted it")
+	}
+	s.expect(http.StatusOK, "PUT", "/blog/update/"+id, `{"title":"Hello","cover":{"image_path":"images/d.png"}}`, admin, nil)
+	if s.stored("images/c.png") || !s.stored("images/d.png") {
+		t.Error("replacing the cover did not delete only the old one")
+	}
+}
+
+// auditEntries lists the audit entries for action, newest first.
+func (s *testServer) auditEntries(token, action string) []models.AuditEntry {
+	s.t.Helper()
+	var page struct {
+		Items []models.AuditEntry `json:"items"`
+	}
+	s.expect(http.StatusOK, "GET", "/admin/audit-log?action="+url.QueryEscape(action), "", token, &page)
+	return page.Items
+}
+
+// changeOf returns the change to field, or nil.
+func changeOf(entry models.AuditEntry, field string) *models.FieldChange {
+	for i := range entry.Changes {
+		if entry.Changes[i].Field == field {
+			return &entry.Changes[i]
+		}
+	}
+	return nil
+}
+
+func TestAuditDeletes(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+	user, err := s.store.Users.FindByEmail(context.Background(), "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.expect(http.StatusCreated, "POST", "/service/create", `{"title":"Hosting","description":"Servers"}`, admin, nil)
+	var services listed
+	s.expect(http.StatusOK, "GET", "/service/get-all", "", "", &services)
+	id := services.Items[0]["_id"].(string)
+
+	s.expect(http.StatusOK, "DELETE", "/service/delete/"+id, "", admin, nil)
+	deleted := s.auditEntries(admin, "DELETE /service/delete/:id")
+	if len(deleted) != 1 || deleted[0].Resource_ID != id {
+		t.Fatalf("delete entries = %+v, want one for %s", deleted, id)
+	}
+	if change := changeOf(deleted[0], "deleted_by"); change == nil || change.Before != nil || change.After != user.User_ID {
+		t.Errorf("delete changed deleted_by %+v, want it set to %s", change, user.User_ID)
+	}
+	if changeOf(deleted[0], "deleted_at") == nil || changeOf(deleted[0], "title") != nil {
+		t.Errorf("delete changes = %+v, want deleted_at and deleted_by only", deleted[0].Changes)
+	}
+
+	s.expect(http.StatusOK, "POST", "/service/restore/"+id, "", admin, nil)
+	restored := s.auditEntries(admin, "POST /service/restore/:id")
+	if len(restored) != 1 || changeOf(restored[0], "deleted_by") == nil || changeOf(restored[0], "deleted_by").After != nil {
+		t.Errorf("restore entries = %+v, want deleted_by cleared", restored)
+	}
+
+	// A purge keeps what was removed.
+	s.expect(http.StatusOK, "DELETE", "/service/delete/"+id, "", admin, nil)
+	s.expect(http.StatusOK, "DELETE", "/service/purge/"+id, "", admin, nil)
+	purged := s.auditEntries(admin, "DELETE /service/purge/:id")
+	if len(purged) != 1 {
+		t.Fatalf("purge entries = %+v, want one", purged)
+	}
+	if change := changeOf(purged[0], "title"); change == nil || change.Before != "Hosting" || change.After != nil {
+		t.Errorf("purge changed title %+v, want Hosting removed", change)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+
+	s.expect(http.StatusCreated, "POST", "/project/create", `{"title":"Shop","tech":"Go"}`, admin, nil)
+	w := s.request("GET", "/project/get-all", "", "")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+		t.Fatalf("get-all = %d with ETag %q, want 200 with an ETag", w.Code, w.Header().Get("ETag"))
+	}
+	if w := s.requestHeader("GET", "/project/get-all", "", "", "If-None-Match", w.Header().Get("ETag")); w.Code != http.StatusNotModified {
+		t.Errorf("get-all with its ETag = %d, want 304", w.Code)
+	}
+	var all listed
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	base := "/project/"
+	id := all.Items[0]["_id"].(string)
+
+	w = s.request("GET", base+"get-one/"+id, "", "")
+	stale := w.Header().Get("ETag")
+	if stale == "" {
+		t.Fatal("get-one has no ETag")
+	}
+	if w := s.requestHeader("GET", base+"get-one/"+id, "", "", "If-None-Match", stale); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("get-one with its ETag = %d %q, want an empty 304", w.Code, w.Body.String())
+	}
+
+	w = s.requestHeader("PUT", base+"update/"+id, `{"title":"Webshop"}`, admin, "If-Match", stale)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update with the current ETag = %d %s, want 200", w.Code, w.Body.String())
+	}
+	current := w.Header().Get("ETag")
+	if current == stale {
+		t.Fatalf("update left the ETag at %s", stale)
+	}
+	if w := s.requestHeader("GET", base+"get-one/"+id, "", "", "If-None-Match", stale); w.Code != http.StatusOK {
+		t.Errorf("get-one with a stale ETag = %d, want 200", w.Code)
+	}
+	if w := s.requestHeader("PUT", base+"update/"+id, `{"title":"Shop"}`, admin, "If-Match", stale); w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != current {
+		t.Errorf("update with a stale ETag = %d with ETag %q, want 412 with %s", w.Code, w.Header().Get("ETag"), current)
+	}
+	if w := s.requestHeader("DELETE", base+"delete/"+id, "", admin, "If-Match", stale); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("delete with a stale ETag = %d, want 412", w.Code)
+	}
+	s.expect(http.StatusOK, "GET", base+"get-one/"+id, "", "", nil)
+	if w := s.requestHeader("DELETE", base+"delete/"+id, "", admin, "If-Match", current); w.Code != http.StatusOK {
+		t.Errorf("delete with the current ETag = %d %s, want 200", w.Code, w.Body.String())
+	}
+
+	// Users are versioned too, including by password changes.
+	w = s.request("GET", "/user/me", "", admin)
+	stale = w.Header().Get("ETag")
+	if w := s.requestHeader("GET", "/user/me", "", admin, "If-None-Match", stale); w.Code != http.StatusNotModified {
+		t.Errorf("/user/me with its ETag = %d, want 304", w.Code)
+	}
+	if w := s.requestHeader("PUT", "/user/update-password", `{"old_password":"secret1","new_password":"secret2"}`, admin, "If-Match", stale); w.Code != http.StatusOK {
+		t.Fatalf("update-password with the current ETag = %d %s, want 200", w.Code, w.Body.String())
+	}
+	if w := s.requestHeader("PUT", "/user/update-password", `{"old_password":"secret2","new_password":"secret3"}`, admin, "If-Match", stale); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("update-password with a stale ETag = %d, want 412", w.Code)
+	}
+	if w := s.requestHeader("PUT", "/user/update-info", `{"name":"Ann"}`, admin, "If-Match", stale); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("update-info with a stale ETag = %d, want 412", w.Code)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "192.0.2.1")
+	t.Setenv("RATE_LIMIT_CONTACT", "2/1h")
+	t.Setenv("RATE_LIMIT_UPLOAD", "1/1h")
+	t.Setenv("RATE_LIMIT_REGISTER", "off")
+	s := newTestServer(t)
+	contact := `{"name":"Vic","email":"vic@example.com","message":"Hi there"}`
+
+	for i, remaining := range []string{"1", "0"} {
+		w := s.requestHeader("POST", "/email/create", contact, "", "X-Forwarded-For", "203.0.113.1")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("contact %d = %d %s, want 201", i+1, w.Code, w.Body.String())
+		}
+		if w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != remaining {
+			t.Errorf("contact %d RateLimit-Limit %q, RateLimit-Remaining %q, want 2 and %s", i+1,
+				w.Header().Get("RateLimit-Limit"), w.Header().Get("RateLimit-Remaining"), remaining)
+		}
+	}
+	w := s.requestHeader("POST", "/email/create", contact, "", "X-Forwarded-For", "203.0.113.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("contact over the limit = %d, want 429", w.Code)
+	}
+	// One request comes back every half hour.
+	if w.Header().Get("Retry-After") != "1800" || w.Header().Get("RateLimit-Reset") != "3600" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("refusal headers = %v, want Retry-After 1800 and RateLimit-Reset 3600", w.Header())
+	}
+	if w := s.requestHeader("POST", "/email/create", contact, "", "X-Forwarded-For", "203.0.113.2"); w.Code != http.StatusCreated {
+		t.Errorf("contact from another address = %d, want 201", w.Code)
+	}
+
+	// A disabled limit neither refuses nor reports.
+	for i := 0; i < 12; i++ {
+		w := s.request("POST", "/user/register", `{}`, "")
+		if w.Code != http.StatusBadRequest || w.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("register %d = %d with RateLimit-Limit %q, want 400 without", i+1, w.Code, w.Header().Get("RateLimit-Limit"))
+		}
+	}
+
+	// Uploads are counted per user, whatever their address.
+	ann := s.signUp("ann@example.com", 0).Token
+	bob := s.signUp("bob@example.com", 0).Token
+	s.expect(http.StatusBadRequest, "POST", "/user/avatar", "", ann, nil)
+	s.expect(http.StatusTooManyRequests, "POST", "/user/avatar", "", ann, nil)
+	s.expect(http.StatusBadRequest, "POST", "/user/avatar", "", bob, nil)
+}
+
+func TestTrashRoutes(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+	user := s.signUp("user@example.com", 0).Token
+	adminUser, err := s.store.Users.FindByEmail(context.Background(), "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.expect(http.StatusCreated, "POST", "/remark/create", `{"name":"Ann","remark":"Great work"}`, admin, nil)
+	s.expect(http.StatusCreated, "POST", "/remark/create", `{"name":"Bob","remark":"Fast"}`, admin, nil)
+	var all listed
+	s.expect(http.StatusOK, "GET", "/remark/get-all?sort=name", "", "", &all)
+	id := all.Items[0]["_id"].(string)
+	s.expect(http.StatusOK, "DELETE", "/remark/delete/"+id, "", admin, nil)
+
+	s.expect(http.StatusOK, "GET", "/remark/get-all", "", "", &all)
+	if all.Total != 1 || all.Items[0]["name"] != "Bob" {
+		t.Errorf("get-all after delete = %+v, want only Bob", all)
+	}
+	s.expect(http.StatusForbidden, "GET", "/remark/trash", "", user, nil)
+	var trash listed
+	s.expect(http.StatusOK, "GET", "/remark/trash", "", admin, &trash)
+	if trash.Total != 1 || trash.Items[0]["_id"] != id || trash.Items[0]["deleted_by"] != adminUser.User_ID || trash.Items[0]["deleted_at"] == nil {
+		t.Fatalf("trash = %+v, want the deleted remark, deleted by the admin", trash)
+	}
+
+	var restored map[string]interface{}
+	s.expect(http.StatusOK, "POST", "/remark/restore/"+id, "", admin, &restored)
+	if restored["name"] != "Ann" || restored["deleted_at"] != nil {
+		t.Errorf("restored remark = %v, want Ann outside the trash", restored)
+	}
+	s.expect(http.StatusOK, "GET", "/remark/trash", "", admin, &trash)
+	if trash.Total != 0 {
+		t.Errorf("trash after restore = %+v, want it empty", trash)
+	}
+	s.expect(http.StatusNotFound, "POST", "/remark/restore/"+id, "", admin, nil)
+	s.expect(http.StatusNotFound, "DELETE", "/remark/purge/"+id, "", admin, nil)
+
+	// Messages and users have their own trash.
+	s.expect(http.StatusCreated, "POST", "/email/create", `{"name":"Vic","email":"vic@example.com","message":"Hi there"}`, "", nil)
+	s.expect(http.StatusOK, "GET", "/email/get-all", "", admin, &all)
+	messageID := all.Items[0]["_id"].(string)
+	s.expect(http.StatusOK, "DELETE", "/email/delete/"+messageID, "", admin, nil)
+	s.expect(http.StatusOK, "GET", "/email/trash", "", admin, &trash)
+	if trash.Total != 1 || trash.Items[0]["_id"] != messageID {
+		t.Errorf("email trash = %+v, want the deleted message", trash)
+	}
+	s.expect(http.StatusOK, "DELETE", "/email/purge/"+messageID, "", admin, nil)
+	s.expect(http.StatusOK, "GET", "/email/trash", "", admin, &trash)
+	if trash.Total != 0 {
+		t.Errorf("email trash after purge = %+v, want it empty", trash)
+	}
+
+	userDoc, err := s.store.Users.FindByEmail(context.Background(), "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.expect(http.StatusOK, "DELETE", "/admin/delete-user/"+userDoc.User_ID, "", admin, nil)
+	s.expect(http.StatusUnauthorized, "GET", "/user/me", "", user, nil)
+	s.expect(http.StatusOK, "GET", "/admin/trashed-users", "", admin, &trash)
+	if trash.Total != 1 || trash.Items[0]["email"] != "user@example.com" {
+		t.Errorf("user trash = %+v, want the deleted user", trash)
+	}
+	s.expect(http.StatusOK, "POST", "/admin/restore-user/"+userDoc.User_ID, "", admin, nil)
+	s.expect(http.StatusFound, "POST", "/user/login", `{"email":"user@example.com","password":"secret1"}`, "", nil)
+}
+
+// linkToken returns the token of the link in msg.
+func linkToken(t *testing.T, msg mail.Message) string {
+	t.Helper()
+	link, err := url.Parse(mailLink.FindString(msg.Text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkToken := link.Query().Get("token")
+	if linkToken == "" {
+		t.Fatalf("mail %q has no link with a token", msg.Text)
+	}
+	return linkToken
+}
+
+func TestEmailVerification(t *testing.T) {
+	t.Setenv("EMAIL_VERIFICATION_POLICY", "login")
+	s := newTestServer(t)
+	ctx := context.Background()
+	login := `{"email":"ann@example.com","password":"secret1"}`
+
+	s.expect(http.StatusCreated, "POST", "/user/register", `{"name":"Ann","email":"ann@example.com","password":"secret1"}`, "", nil)
+	user, err := s.store.Users.FindByEmail(ctx, "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.IsVerified() || user.Verified_At != nil {
+		t.Fatalf("registered user = %+v, want it unverified", user)
+	}
+	s.expect(http.StatusForbidden, "POST", "/user/login", login, "", nil)
+	first := linkToken(t, s.mailTo("ann@example.com", "Confirm your email address"))
+
+	// A link resent with a TTL that has already run out is expired.
+	t.Setenv("EMAIL_VERIFICATION_TTL", "-1m")
+	s.expect(http.StatusOK, "POST", "/user/resend-verification", `{"email":"ann@example.com"}`, "", nil)
+	expired := first
+	for deadline := time.Now().Add(5 * time.Second); expired == first && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		expired = linkToken(t, s.mailTo("ann@example.com", "Confirm your email address"))
+	}
+	if expired == first {
+		t.Fatal("no new verification mail was sent")
+	}
+	s.expect(http.StatusBadRequest, "POST", "/user/verify-email", `{"token":"`+expired+`"}`, "", nil)
+	s.expect(http.StatusBadRequest, "POST", "/user/verify-email", `{"token":"not-a-token"}`, "", nil)
+
+	s.expect(http.StatusOK, "POST", "/user/verify-email", `{"token":"`+first+`"}`, "", nil)
+	if user, err = s.store.Users.FindByEmail(ctx, "ann@example.com"); err != nil || !user.IsVerified() || user.Verified_At == nil {
+		t.Fatalf("user after verification = %+v, %v, want it verified", user, err)
+	}
+	s.expect(http.StatusBadRequest, "POST", "/user/verify-email", `{"token":"`+first+`"}`, "", nil)
+
+	var session tokens
+	s.expect(http.StatusFound, "POST", "/user/login", login, "", &session)
+	// Only verification tokens verify.
+	s.expect(http.StatusBadRequest, "POST", "/user/verify-email", `{"token":"`+session.Token+`"}`, "", nil)
+}
+
+func TestAuditLogExport(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.signUp("admin@example.com", 1).Token
+	user := s.signUp("user@example.com", 0).Token
+	adminUser, err := s.store.Users.FindByEmail(context.Background(), "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.expect(http.StatusCreated, "POST", "/service/create", `{"title":"Hosting"}`, admin, nil)
+	s.expect(http.StatusCreated, "POST", "/project/create", `{"title":"Shop"}`, admin, nil)
+	s.expect(http.StatusForbidden, "GET", "/admin/audit-log", "", user, nil)
+
+	var page struct {
+		Items []models.AuditEntry `json:"items"`
+		Total int64               `json:"total"`
+	}
+	s.expect(http.StatusOK, "GET", "/admin/audit-log?resource=service", "", admin, &page)
+	if page.Total != 1 {
+		t.Fatalf("audit log for services = %+v, want one entry", page)
+	}
+	entry := page.Items[0]
+	if entry.Actor_ID != adminUser.User_ID || entry.Actor_Email != "admin@example.com" || entry.Action != "POST /service/create" ||
+		entry.Status != http.StatusCreated || entry.IP == "" || changeOf(entry, "title") == nil {
+		t.Errorf("audit entry = %+v, want the admin creating a service titled Hosting", entry)
+	}
+	s.expect(http.StatusBadRequest, "GET", "/admin/audit-log?status=created", "", admin, nil)
+
+	w := s.request("GET", "/admin/audit-log/export?method=POST", "", admin)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("CSV export = %d %s, want CSV", w.Code, w.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := []string{"id", "created_at", "actor_id", "actor_email", "action", "method", "path",
+		"resource", "resource_id", "status", "ip", "user_agent", "changes"}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], header) {
+		t.Fatalf("CSV export = %q, want the header and two entries", rows)
+	}
+	// Newest first.
+	row := rows[1]
+	if row[3] != "admin@example.com" || row[4] != "POST /project/create" || row[5] != "POST" || row[6] != "/project/create" ||
+		row[7] != "project" || row[9] != "201" {
+		t.Errorf("CSV row = %q, want the project being created", row)
+	}
+	var changes []models.FieldChange
+	if err := json.Unmarshal([]byte(row[12]), &changes); err != nil || len(changes) == 0 {
+		t.Errorf("CSV changes %q: %v, want a JSON list of changes", row[12], err)
+	}
+	if _, err := time.Parse(time.RFC3339, row[1]); err != nil {
+		t.Errorf("CSV created_at %q: %v", row[1], err)
+	}
+
+	var exported []models.AuditEntry
+	s.expect(http.StatusOK, "GET", "/admin/audit-log/export?format=json&resource=project", "", admin, &exported)
+	if len(exported) != 1 || exported[0].Action != "POST /project/create" {
+		t.Errorf("JSON export = %+v, want the project entry", exported)
+	}
+	s.expect(http.StatusBadRequest, "GET", "/admin/audit-log/export?format=xml", "", admin, nil)
+}
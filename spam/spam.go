@@ -0,0 +1,189 @@
+// Package spam decides whether a contact form submission is spam.
+package spam
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"nanosoft/config"
+	"nanosoft/repository"
+	token "nanosoft/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Reasons a submission is considered spam.
+const (
+	ReasonHoneypot       = "honeypot"
+	ReasonFormToken      = "invalid_form_token"
+	ReasonTooFast        = "too_fast"
+	ReasonKeywords       = "keywords"
+	ReasonLinks          = "too_many_links"
+	ReasonDuplicateIP    = "duplicate_ip"
+	ReasonDuplicateEmail = "duplicate_email"
+	ReasonCaptchaError   = "captcha_unavailable"
+)
+
+// ErrCaptchaFailed is returned by Check when the CAPTCHA was not solved.
+// Unlike the other checks it is not a matter of judgement, so the visitor
+// is told and can try again.
+var ErrCaptchaFailed = errors.New("captcha verification failed")
+
+// Config tunes the checks. A submission is spam when its hidden honeypot
+// field is filled in, when it carries a form token that is not valid, or
+// none while RequireFormToken is set, when it comes back sooner than
+// MinSubmitTime after its form token was issued, when it contains
+// KeywordThreshold or more of Keywords as whole words, when it has more
+// than MaxLinks links, or when the same text was already sent from the
+// same address or email within DuplicateWindow. Zero MinSubmitTime,
+// KeywordThreshold or DuplicateWindow and a negative MaxLinks disable that
+// check.
+type Config struct {
+	Honeypot bool
+	// RequireFormToken is for frontends that fetch /email/form-token
+	// before showing the form.
+	RequireFormToken bool
+	MinSubmitTime    time.Duration
+	Keywords         []string
+	KeywordThreshold int
+	MaxLinks         int
+	DuplicateWindow  time.Duration
+	CaptchaProvider  string
+	CaptchaStubToken string
+}
+
+var defaultKeywords = []string{
+	"viagra", "cialis", "casino", "crypto", "bitcoin", "forex", "loan", "seo services", "backlinks", "porn",
+}
+
+func LoadConfig() Config {
+	return Config{
+		Honeypot:         config.Bool("SPAM_HONEYPOT", true),
+		RequireFormToken: config.Bool("SPAM_REQUIRE_FORM_TOKEN", false),
+		MinSubmitTime:    config.Duration("SPAM_MIN_SUBMIT_TIME", 3*time.Second),
+		Keywords:         config.List("SPAM_KEYWORDS", defaultKeywords),
+		KeywordThreshold: config.Int("SPAM_KEYWORD_THRESHOLD", 2),
+		MaxLinks:         config.Int("SPAM_MAX_LINKS", 2),
+		DuplicateWindow:  config.Duration("SPAM_DUPLICATE_WINDOW", 24*time.Hour),
+		CaptchaProvider:  config.String("CAPTCHA_PROVIDER", ProviderNone),
+		CaptchaStubToken: config.String("CAPTCHA_STUB_TOKEN", ""),
+	}
+}
+
+// Submission is what the filter sees of one contact form post. Text is all
+// the visitor wrote; messages are stored with its ContentHash so repeats of
+// it can be found.
+type Submission struct {
+	IP           string
+	Email        string
+	Text         string
+	Honeypot     string
+	FormToken    string
+	CaptchaToken string
+	At           time.Time
+}
+
+// Filter runs every check on a submission. Messages is searched for
+// earlier copies of it.
+type Filter struct {
+	Config   Config
+	Messages repository.MessageRepository
+	Verifier Verifier
+}
+
+func NewFilter(cfg Config, messages repository.MessageRepository) *Filter {
+	return &Filter{Config: cfg, Messages: messages, Verifier: NewVerifier(cfg)}
+}
+
+var linkPattern = regexp.MustCompile(`(?i)https?://|www\.|\[url`)
+
+// ContentHash identifies the text of a message for duplicate detection,
+// ignoring case and spacing.
+func ContentHash(text string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.Join(strings.Fields(text), " "))))
+	return hex.EncodeToString(sum[:])
+}
+
+// containsWord reports whether text contains phrase as whole words, so
+// "loan" matches "a loan offer" but not "loans app", ignoring case.
+func containsWord(text, phrase string) bool {
+	pattern := `(?i)(^|\W)` + regexp.QuoteMeta(phrase) + `($|\W)`
+	return regexp.MustCompile(pattern).MatchString(text)
+}
+
+// Check returns why s looks like spam, or nothing if it does not. A failed
+// lookup skips its check rather than losing the message.
+func (f *Filter) Check(ctx context.Context, s Submission) ([]string, error) {
+	var reasons []string
+	if f.Verifier != nil {
+		ok, err := f.Verifier.Verify(ctx, s.CaptchaToken, s.IP)
+		if err != nil {
+			log.Println("Error verifying CAPTCHA:", err)
+			reasons = append(reasons, ReasonCaptchaError)
+		} else if !ok {
+			return nil, ErrCaptchaFailed
+		}
+	}
+
+	if f.Config.Honeypot && strings.TrimSpace(s.Honeypot) != "" {
+		reasons = append(reasons, ReasonHoneypot)
+	}
+
+	if s.FormToken != "" {
+		claims, msg := token.ValidateToken(s.FormToken)
+		switch {
+		case msg != "" || claims.Type != token.ContactForm:
+			reasons = append(reasons, ReasonFormToken)
+		case f.Config.MinSubmitTime > 0 && s.At.Sub(time.Unix(claims.IssuedAt, 0)) < f.Config.MinSubmitTime:
+			reasons = append(reasons, ReasonTooFast)
+		}
+	} else if f.Config.RequireFormToken {
+		reasons = append(reasons, ReasonFormToken)
+	}
+
+	if f.Config.KeywordThreshold > 0 {
+		found := 0
+		for _, keyword := range f.Config.Keywords {
+			if keyword = strings.TrimSpace(keyword); keyword != "" && containsWord(s.Text, keyword) {
+				found++
+			}
+		}
+		if found >= f.Config.KeywordThreshold {
+			reasons = append(reasons, ReasonKeywords)
+		}
+	}
+
+	if f.Config.MaxLinks >= 0 && len(linkPattern.FindAllStringIndex(s.Text, -1)) > f.Config.MaxLinks {
+		reasons = append(reasons, ReasonLinks)
+	}
+
+	if f.Config.DuplicateWindow > 0 {
+		since := s.At.Add(-f.Config.DuplicateWindow)
+		hash := ContentHash(s.Text)
+		for _, dup := range []struct{ reason, field, value string }{
+			{ReasonDuplicateIP, "ip", s.IP},
+			{ReasonDuplicateEmail, "email", s.Email},
+		} {
+			count, err := f.Messages.Count(ctx, bson.M{
+				dup.field:      dup.value,
+				"content_hash": hash,
+				"created_at":   bson.M{"$gte": since},
+			})
+			if err != nil {
+				log.Println("Error looking for duplicate messages:", err)
+				continue
+			}
+			if count > 0 {
+				reasons = append(reasons, dup.reason)
+			}
+		}
+	}
+
+	return reasons, nil
+}
@@ -0,0 +1,221 @@
+package spam
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"nanosoft/models"
+	"nanosoft/repository"
+	token "nanosoft/tokens"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("SECRET_KEY", "test-secret")
+	os.Exit(m.Run())
+}
+
+// quiet has every check turned off, so a test can turn on the one it is
+// about.
+var quiet = Config{MaxLinks: -1}
+
+// verifierFunc adapts a function to Verifier.
+type verifierFunc func(response string) (bool, error)
+
+func (f verifierFunc) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	return f(response)
+}
+
+func check(t *testing.T, f *Filter, s Submission) []string {
+	t.Helper()
+	if s.At.IsZero() {
+		s.At = time.Now()
+	}
+	reasons, err := f.Check(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Check(%+v): %v", s, err)
+	}
+	return reasons
+}
+
+func TestHoneypot(t *testing.T) {
+	cfg := quiet
+	cfg.Honeypot = true
+	f := &Filter{Config: cfg}
+
+	if got := check(t, f, Submission{Honeypot: "https://spam.example.com"}); !reflect.DeepEqual(got, []string{ReasonHoneypot}) {
+		t.Errorf("filled honeypot = %v, want %s", got, ReasonHoneypot)
+	}
+	if got := check(t, f, Submission{Honeypot: "  "}); got != nil {
+		t.Errorf("blank honeypot = %v, want nothing", got)
+	}
+	f.Config.Honeypot = false
+	if got := check(t, f, Submission{Honeypot: "x"}); got != nil {
+		t.Errorf("disabled honeypot = %v, want nothing", got)
+	}
+}
+
+func TestFormToken(t *testing.T) {
+	formToken, err := token.ContactFormTokenGenerator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := token.VerificationTokenGenerator("ann@example.com", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, msg := token.ValidateToken(formToken)
+	if msg != "" {
+		t.Fatal(msg)
+	}
+	issued := time.Unix(claims.IssuedAt, 0)
+
+	cfg := quiet
+	cfg.MinSubmitTime = 3 * time.Second
+	tests := []struct {
+		name     string
+		require  bool
+		token    string
+		after    time.Duration
+		want     []string
+		disabled bool
+	}{
+		{name: "no token", token: "", want: nil},
+		{name: "no token when required", require: true, token: "", want: []string{ReasonFormToken}},
+		{name: "forged token", token: "not-a-token", want: []string{ReasonFormToken}},
+		{name: "token of another kind", token: otherToken, after: time.Minute, want: []string{ReasonFormToken}},
+		{name: "sent too fast", token: formToken, after: time.Second, want: []string{ReasonTooFast}},
+		{name: "sent after a while", token: formToken, after: time.Minute, want: nil},
+		{name: "timing disabled", token: formToken, after: time.Second, disabled: true, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Filter{Config: cfg}
+			f.Config.RequireFormToken = tt.require
+			if tt.disabled {
+				f.Config.MinSubmitTime = 0
+			}
+			got := check(t, f, Submission{FormToken: tt.token, At: issued.Add(tt.after)})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	cfg := quiet
+	cfg.Keywords = []string{"casino", "seo services", " ", "loan"}
+	cfg.KeywordThreshold = 2
+	f := &Filter{Config: cfg}
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Best CASINO and seo services here", []string{ReasonKeywords}},
+		{"We need a loan for the casino", []string{ReasonKeywords}},
+		{"Please quote for a casino website", nil},
+		{"Our loans app needs SEO servicesX", nil},
+		{"casino casino casino", nil},
+	}
+	for _, tt := range tests {
+		if got := check(t, f, Submission{Text: tt.text}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Check(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	cfg := quiet
+	cfg.MaxLinks = 2
+	f := &Filter{Config: cfg}
+
+	if got := check(t, f, Submission{Text: "see https://a.example and www.b.example"}); got != nil {
+		t.Errorf("two links = %v, want nothing", got)
+	}
+	if got := check(t, f, Submission{Text: "http://a.example HTTPS://b.example [url=c]"}); !reflect.DeepEqual(got, []string{ReasonLinks}) {
+		t.Errorf("three links = %v, want %s", got, ReasonLinks)
+	}
+	f.Config.MaxLinks = 0
+	if got := check(t, f, Submission{Text: "www.a.example"}); !reflect.DeepEqual(got, []string{ReasonLinks}) {
+		t.Errorf("a link with none allowed = %v, want %s", got, ReasonLinks)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	ctx := context.Background()
+	messages := repository.NewMemoryStore().Messages
+	now := time.Now()
+	email := "ann@example.com"
+	err := messages.Create(ctx, &models.Message{
+		Message_ID:   primitive.NewObjectID(),
+		Email:        &email,
+		IP:           "192.0.2.1",
+		Content_Hash: ContentHash("Hello   there"),
+		Created_At:   now.Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := quiet
+	cfg.DuplicateWindow = 24 * time.Hour
+	f := &Filter{Config: cfg, Messages: messages}
+	tests := []struct {
+		name string
+		s    Submission
+		want []string
+	}{
+		{"same IP and email", Submission{IP: "192.0.2.1", Email: email, Text: "hello there"}, []string{ReasonDuplicateIP, ReasonDuplicateEmail}},
+		{"same IP", Submission{IP: "192.0.2.1", Email: "bob@example.com", Text: "HELLO THERE"}, []string{ReasonDuplicateIP}},
+		{"same email", Submission{IP: "192.0.2.2", Email: email, Text: "Hello there"}, []string{ReasonDuplicateEmail}},
+		{"other text", Submission{IP: "192.0.2.1", Email: email, Text: "Hello again"}, nil},
+		{"outside the window", Submission{IP: "192.0.2.1", Email: email, Text: "Hello there", At: now.Add(48 * time.Hour)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := check(t, f, tt.s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaptcha(t *testing.T) {
+	cfg := quiet
+	cfg.CaptchaProvider = ProviderStub
+	cfg.CaptchaStubToken = "solved"
+	f := &Filter{Config: cfg, Verifier: NewVerifier(cfg)}
+
+	if got := check(t, f, Submission{CaptchaToken: "solved"}); got != nil {
+		t.Errorf("solved CAPTCHA = %v, want nothing", got)
+	}
+	if _, err := f.Check(context.Background(), Submission{CaptchaToken: "wrong", At: time.Now()}); !errors.Is(err, ErrCaptchaFailed) {
+		t.Errorf("wrong CAPTCHA = %v, want ErrCaptchaFailed", err)
+	}
+
+	// The message is kept, for review, when the provider cannot be asked.
+	f.Verifier = verifierFunc(func(string) (bool, error) { return false, errors.New("timeout") })
+	if got := check(t, f, Submission{CaptchaToken: "solved"}); !reflect.DeepEqual(got, []string{ReasonCaptchaError}) {
+		t.Errorf("unreachable CAPTCHA = %v, want %s", got, ReasonCaptchaError)
+	}
+
+	if NewVerifier(quiet) != nil {
+		t.Error("NewVerifier without a provider is not nil")
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	if ContentHash("Hello  World\n") != ContentHash("hello world") {
+		t.Error("ContentHash depends on case or spacing")
+	}
+	if ContentHash("hello world") == ContentHash("hello, world") {
+		t.Error("ContentHash ignores punctuation")
+	}
+}
@@ -0,0 +1,44 @@
+package spam
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+)
+
+// CAPTCHA providers, selected by CAPTCHA_PROVIDER.
+const (
+	ProviderNone = ""
+	ProviderStub = "stub"
+)
+
+// Verifier checks the response token a CAPTCHA widget gave the visitor.
+// It returns false for a wrong answer and an error when the provider could
+// not be asked.
+type Verifier interface {
+	Verify(ctx context.Context, response, remoteIP string) (bool, error)
+}
+
+// Stub is a Verifier for development and tests that accepts exactly Token,
+// set by CAPTCHA_STUB_TOKEN.
+type Stub struct {
+	Token string
+}
+
+func (s *Stub) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	return s.Token != "" && subtle.ConstantTimeCompare([]byte(response), []byte(s.Token)) == 1, nil
+}
+
+// NewVerifier returns the Verifier for cfg.CaptchaProvider, or nil when no
+// CAPTCHA is required.
+func NewVerifier(cfg Config) Verifier {
+	switch cfg.CaptchaProvider {
+	case ProviderNone:
+		return nil
+	case ProviderStub:
+		return &Stub{Token: cfg.CaptchaStubToken}
+	default:
+		log.Printf("config: CAPTCHA_PROVIDER=%q is not supported, no CAPTCHA will be required", cfg.CaptchaProvider)
+		return nil
+	}
+}
@@ -0,0 +1,182 @@
+// Package storage keeps uploaded files. Files are addressed by a relative,
+// slash-separated path such as "images/2026/10/6523….png", which is what
+// the models store as their image_path; URL turns it into the address
+// clients download the file from.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"nanosoft/config"
+)
+
+var (
+	// ErrNotFound is returned for a path that holds no file.
+	ErrNotFound = errors.New("file not found")
+	// ErrInvalidPath is returned for a path that is absolute or leaves the
+	// storage root.
+	ErrInvalidPath = errors.New("invalid file path")
+)
+
+// Storage saves, serves and deletes files by path.
+type Storage interface {
+	// Save stores the contents of r at path, replacing any file there.
+	Save(ctx context.Context, path string, r io.Reader) error
+	// Open returns the file at path. The caller closes it.
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+	// Delete removes the file at path. A missing file is not an error.
+	Delete(ctx context.Context, path string) error
+	// URL is the public address of the file at path.
+	URL(path string) string
+}
+
+type Config struct {
+	// Dir is the directory the local storage keeps files in.
+	Dir string
+	// BaseURL is the public address files are served under.
+	BaseURL string
+}
+
+func LoadConfig() Config {
+	return Config{
+		Dir:     config.String("UPLOAD_DIR", "uploads"),
+		BaseURL: config.String("UPLOAD_BASE_URL", "http://localhost:8000/uploads"),
+	}
+}
+
+// Local stores files in a directory on disk.
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocal(cfg Config) (*Local, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{dir: cfg.Dir, baseURL: strings.TrimSuffix(cfg.BaseURL, "/")}, nil
+}
+
+// CleanPath checks that p is a relative path inside the storage root and
+// returns it in canonical form.
+func CleanPath(p string) (string, error) {
+	if p == "" || strings.Contains(p, "\\") || strings.HasPrefix(p, "/") {
+		return "", ErrInvalidPath
+	}
+	cleaned := path.Clean(p)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidPath
+	}
+	return cleaned, nil
+}
+
+func (l *Local) file(p string) (string, error) {
+	cleaned, err := CleanPath(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(cleaned)), nil
+}
+
+// Save writes to a temporary file first, so a failed upload never leaves a
+// partial file at path.
+func (l *Local) Save(ctx context.Context, p string, r io.Reader) error {
+	name, err := l.file(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	name, err := l.file(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+	return f, nil
+}
+
+func (l *Local) Delete(ctx context.Context, p string) error {
+	name, err := l.file(p)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) URL(p string) string {
+	return l.baseURL + "/" + (&url.URL{Path: p}).EscapedPath()
+}
+
+// DeleteAll deletes the files at paths, logging the ones that could not be
+// deleted. Paths outside the storage root, such as those of images hosted
+// elsewhere, are skipped.
+func DeleteAll(ctx context.Context, s Storage, paths []string) {
+	for _, p := range paths {
+		if _, err := CleanPath(p); err != nil {
+			continue
+		}
+		if err := s.Delete(ctx, p); err != nil {
+			log.Printf("Error deleting stored file %s: %v", p, err)
+		}
+	}
+}
+
+// DeleteReplaced deletes the files of a document that it referred to
+// before a change and no longer does after it.
+func DeleteReplaced(ctx context.Context, s Storage, before, after []string) {
+	kept := make(map[string]bool, len(after))
+	for _, p := range after {
+		kept[p] = true
+	}
+	var replaced []string
+	for _, p := range before {
+		if !kept[p] {
+			replaced = append(replaced, p)
+		}
+	}
+	DeleteAll(ctx, s, replaced)
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "images/a.png", want: "images/a.png"},
+		{path: "images/./a.png", want: "images/a.png"},
+		{path: "images//a.png", want: "images/a.png"},
+		{path: "images/x/../a.png", want: "images/a.png"},
+		{path: "", wantErr: true},
+		{path: ".", wantErr: true},
+		{path: "..", wantErr: true},
+		{path: "../x", wantErr: true},
+		{path: "../../etc/passwd", wantErr: true},
+		{path: "images/../../x", wantErr: true},
+		{path: "images/..", wantErr: true},
+		{path: "/etc/passwd", wantErr: true},
+		{path: "//host/share", wantErr: true},
+		{path: "..\\x", wantErr: true},
+		{path: "images\\a.png", wantErr: true},
+		{path: "C:\\x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := CleanPath(tt.path)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("CleanPath(%q) = %q, %v, want ErrInvalidPath", tt.path, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("CleanPath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func newTestLocal(t *testing.T) (*Local, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "uploads")
+	l, err := NewLocal(Config{Dir: dir, BaseURL: "https://example.com/uploads/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, dir
+}
+
+func read(t *testing.T, l *Local, p string) (string, error) {
+	t.Helper()
+	f, err := l.Open(context.Background(), p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), nil
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	l, dir := newTestLocal(t)
+
+	if err := l.Save(ctx, "images/2026/10/a.png", strings.NewReader("first")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := read(t, l, "images/2026/10/a.png"); err != nil || got != "first" {
+		t.Fatalf("Open after Save = %q, %v, want first", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "images", "2026", "10", "a.png")); err != nil {
+		t.Errorf("file is not under the storage directory: %v", err)
+	}
+
+	if err := l.Save(ctx, "images/2026/10/a.png", strings.NewReader("second")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := read(t, l, "images/2026/10/a.png"); got != "second" {
+		t.Errorf("Open after replacing = %q, want second", got)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "images", "2026", "10"))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %v, %v, want only a.png", entries, err)
+	}
+
+	if _, err := l.Open(ctx, "images"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open of a directory = %v, want ErrNotFound", err)
+	}
+	if _, err := l.Open(ctx, "images/missing.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open of a missing file = %v, want ErrNotFound", err)
+	}
+
+	if err := l.Delete(ctx, "images/2026/10/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Open(ctx, "images/2026/10/a.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete = %v, want ErrNotFound", err)
+	}
+	if err := l.Delete(ctx, "images/2026/10/a.png"); err != nil {
+		t.Errorf("Delete of a missing file = %v, want nil", err)
+	}
+}
+
+func TestLocalStaysInsideDir(t *testing.T) {
+	ctx := context.Background()
+	l, dir := newTestLocal(t)
+	outside := filepath.Join(filepath.Dir(dir), "secret.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"../secret.txt", "images/../../secret.txt", outside, "..\\secret.txt"} {
+		if _, err := l.Open(ctx, p); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Open(%q) = %v, want ErrInvalidPath", p, err)
+		}
+		if err := l.Save(ctx, p, strings.NewReader("x")); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Save(%q) = %v, want ErrInvalidPath", p, err)
+		}
+		if err := l.Delete(ctx, p); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Delete(%q) = %v, want ErrInvalidPath", p, err)
+		}
+	}
+	if b, err := os.ReadFile(outside); err != nil || string(b) != "secret" {
+		t.Errorf("file outside the storage directory = %q, %v, want it untouched", b, err)
+	}
+}
+
+func TestURL(t *testing.T) {
+	l, _ := newTestLocal(t)
+	if got, want := l.URL("images/a b.png"), "https://example.com/uploads/images/a%20b.png"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+}
+
+func TestDeleteReplaced(t *testing.T) {
+	ctx := context.Background()
+	l, _ := newTestLocal(t)
+	for _, p := range []string{"images/a.png", "images/b.png", "images/c.png"} {
+		if err := l.Save(ctx, p, strings.NewReader("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	DeleteReplaced(ctx, l, []string{"images/a.png", "images/b.png", "https://cdn.example.com/x.png"}, []string{"images/b.png", "images/c.png"})
+	for p, want := range map[string]bool{"images/a.png": false, "images/b.png": true, "images/c.png": true} {
+		if _, err := read(t, l, p); (err == nil) != want {
+			t.Errorf("%s stored = %v, want %v", p, err == nil, want)
+		}
+	}
+}
@@ -1,61 +1,169 @@
 package token
 
 import (
-	"context"
-	"log"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
 	"time"
 
-	"nanosoft/database"
+	"nanosoft/config"
 
 	jwt "github.com/dgrijalva/jwt-go"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const (
+	AccessToken       = "access"
+	RefreshToken      = "refresh"
+	EmailVerification = "verify_email"
+	ContactForm       = "contact_form"
+)
+
+// Email verification policies, selected by EMAIL_VERIFICATION_POLICY.
+const (
+	// VerifyOptional lets unverified accounts log in and use the API.
+	VerifyOptional = "optional"
+	// VerifyLogin makes Login refuse unverified accounts.
+	VerifyLogin = "login"
+	// VerifyAccess issues tokens to unverified accounts but makes
+	// middleware.Authentication refuse them.
+	VerifyAccess = "access"
+)
+
+// SignedDetails are the claims of every token type. Sid is the session
+// family a token belongs to and StandardClaims.Id the token's own ID, which
+// for refresh tokens is the ID of its session document. Unverified is set
+// when the user had not confirmed their email address yet; it is omitted
+// otherwise so tokens issued before verification existed stay valid.
 type SignedDetails struct {
-	Email string
-	Name  string
-	Role  int
-	Uid   string
+	Email      string
+	Name       string
+	Role       int
+	Uid        string
+	Type       string
+	Sid        string
+	Unverified bool `json:",omitempty"`
 	jwt.StandardClaims
 }
 
-var UserData *mongo.Collection = database.UserData(database.Client, "Users")
-var SECRET_KEY = os.Getenv("SECRET_KEY")
+// The secret is read on use rather than at start-up so values loaded from
+// .env by config.Load are picked up.
+func secretKey() []byte {
+	return []byte(os.Getenv("SECRET_KEY"))
+}
+
+func AccessTokenTTL() time.Duration {
+	return config.Duration("ACCESS_TOKEN_TTL", 24*time.Hour)
+}
+
+func RefreshTokenTTL() time.Duration {
+	return config.Duration("REFRESH_TOKEN_TTL", 168*time.Hour)
+}
+
+func PasswordResetTTL() time.Duration {
+	return config.Duration("PASSWORD_RESET_TTL", time.Hour)
+}
+
+func EmailVerificationTTL() time.Duration {
+	return config.Duration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+}
 
-func TokenGenerator(email, name, uid string, role int) (signedtoken string, signedrefreshtoken string, err error) {
+// ContactFormTTL is how long a contact form may be left open before it is
+// submitted.
+func ContactFormTTL() time.Duration {
+	return config.Duration("CONTACT_FORM_TOKEN_TTL", 24*time.Hour)
+}
+
+// VerificationPolicy returns the configured email verification policy,
+// falling back to VerifyOptional for unknown values.
+func VerificationPolicy() string {
+	switch policy := config.String("EMAIL_VERIFICATION_POLICY", VerifyOptional); policy {
+	case VerifyOptional, VerifyLogin, VerifyAccess:
+		return policy
+	default:
+		return VerifyOptional
+	}
+}
+
+// TokenGenerator signs an access token and a refresh token for the user.
+// Both carry the user's identity and the session family sid; refreshID
+// becomes the refresh token's ID.
+func TokenGenerator(email, name, uid string, role int, verified bool, sid, refreshID string) (signedtoken string, signedrefreshtoken string, err error) {
+	now := time.Now()
 	claims := &SignedDetails{
-		Email: email,
-		Name:  name,
-		Role:  role,
-		Uid:   uid,
+		Email:      email,
+		Name:       name,
+		Role:       role,
+		Uid:        uid,
+		Type:       AccessToken,
+		Sid:        sid,
+		Unverified: !verified,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			Id:        newTokenID(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL()).Unix(),
 		},
 	}
 	refreshclaims := &SignedDetails{
+		Email: email,
+		Name:  name,
+		Role:  role,
+		Uid:   uid,
+		Type:  RefreshToken,
+		Sid:   sid,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			Id:        refreshID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(RefreshTokenTTL()).Unix(),
 		},
 	}
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secretKey())
 	if err != nil {
 		return "", "", err
 	}
-	refreshtoken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshclaims).SignedString([]byte(SECRET_KEY))
+	refreshtoken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshclaims).SignedString(secretKey())
 	if err != nil {
-		log.Panicln(err)
-		return
+		return "", "", err
+	}
+	return token, refreshtoken, nil
+}
+
+// VerificationTokenGenerator signs the token of an email verification link.
+// It is bound to the address as well as the user, so changing the address
+// invalidates links sent to the old one.
+func VerificationTokenGenerator(email, uid string) (string, error) {
+	now := time.Now()
+	claims := &SignedDetails{
+		Email: email,
+		Uid:   uid,
+		Type:  EmailVerification,
+		StandardClaims: jwt.StandardClaims{
+			Id:        newTokenID(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(EmailVerificationTTL()).Unix(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secretKey())
+}
+
+// ContactFormTokenGenerator signs the token handed out with the contact
+// form. Its issue time shows how long the form was open when it comes back.
+func ContactFormTokenGenerator() (string, error) {
+	now := time.Now()
+	claims := &SignedDetails{
+		Type: ContactForm,
+		StandardClaims: jwt.StandardClaims{
+			Id:        newTokenID(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ContactFormTTL()).Unix(),
+		},
 	}
-	return token, refreshtoken, err
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secretKey())
 }
 
 func ValidateToken(signedtoken string) (claims *SignedDetails, msg string) {
 	token, err := jwt.ParseWithClaims(signedtoken, &SignedDetails{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(SECRET_KEY), nil
+		return secretKey(), nil
 	})
 
 	if err != nil {
@@ -74,26 +182,26 @@ func ValidateToken(signedtoken string) (claims *SignedDetails, msg string) {
 	return claims, msg
 }
 
-func UpdateAllTokens(signedtoken string, signedrefreshtoken string, userid string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-	var updateobj primitive.D
-	updateobj = append(updateobj, bson.E{Key: "token", Value: signedtoken})
-	updateobj = append(updateobj, bson.E{Key: "refresh_token", Value: signedrefreshtoken})
-	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateobj = append(updateobj, bson.E{Key: "updatedat", Value: updated_at})
-	upsert := true
-	filter := bson.M{"user_id": userid}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-	_, err := UserData.UpdateOne(ctx, filter, bson.D{
-		{Key: "$set", Value: updateobj},
-	},
-		&opt)
-	defer cancel()
-	if err != nil {
-		log.Panic(err)
-		return
+// HashToken returns the SHA-256 hex digest under which a token is stored.
+func HashToken(signedtoken string) string {
+	sum := sha256.Sum256([]byte(signedtoken))
+	return hex.EncodeToString(sum[:])
+}
+
+// RandomToken returns an unguessable token for links sent by email. Such
+// tokens are not JWTs; they are stored by HashToken and looked up.
+func RandomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
+	return hex.EncodeToString(b)
+}
 
+func newTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }